@@ -0,0 +1,203 @@
+// Copyright 2023 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rtc
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+
+	"github.com/livekit/protocol/livekit"
+	"github.com/livekit/protocol/logger"
+)
+
+// batchSubscribeLabel is the DataChannel label subscribers open to opt into batched
+// subscription signaling: all tracks requested within the coalesce window are added to a
+// single SDP offer/answer exchange instead of one renegotiation per track, which otherwise
+// dominates join latency in "wall of video" rooms with dozens of participants.
+const batchSubscribeLabel = "lk-sub-batch"
+
+// batchCoalesceWindow bounds how long BatchSubscriber waits for additional subscribe/unsubscribe
+// requests to arrive before it flushes the accumulated set as one renegotiation.
+const batchCoalesceWindow = 15 * time.Millisecond
+
+type batchSubscribeMessage struct {
+	Type      string   `json:"type"`
+	TrackSids []string `json:"trackSids,omitempty"`
+	SDP       string   `json:"sdp,omitempty"`
+}
+
+// BatchSubscriberTrackManager is the minimal surface BatchSubscriber needs from the owning
+// ParticipantImpl: looking up a published track's MediaTrackSubscriptions, triggering the single
+// renegotiation once a batch of subscribe/unsubscribe requests has been coalesced, and answering
+// an SDP offer carried over the batch DataChannel instead of the usual signaling round-trip.
+type BatchSubscriberTrackManager interface {
+	GetSubscriptions(trackID livekit.TrackID) *MediaTrackSubscriptions
+	Negotiate(force bool)
+	HandleClientOffer(sdp webrtc.SessionDescription) (webrtc.SessionDescription, error)
+}
+
+// BatchSubscriber lets a subscriber open one DataChannel (labelled "lk-sub-batch") and request
+// subscriptions to many tracks with a single JSON message instead of one signaling round-trip per
+// track. It coalesces the requested AddTrack calls across the existing per-track
+// MediaTrackSubscriptions flow and issues a single renegotiation for the whole batch.
+type BatchSubscriber struct {
+	subscriberID livekit.ParticipantID
+	tracks       BatchSubscriberTrackManager
+	dc           *webrtc.DataChannel
+	logger       logger.Logger
+
+	lock          sync.Mutex
+	pendingAdd    map[livekit.TrackID]struct{}
+	pendingRemove map[livekit.TrackID]struct{}
+	flushTimer    *time.Timer
+}
+
+// HandleSubscriberDataChannel inspects an incoming DataChannel from a subscriber's PeerConnection
+// and, if it's labelled batchSubscribeLabel ("lk-sub-batch"), constructs and returns the
+// BatchSubscriber that will service it. Returns nil for any other label so callers can wire this
+// straight into their existing pc.OnDataChannel alongside whatever other labels they already
+// handle there:
+//
+//	pc.OnDataChannel(func(dc *webrtc.DataChannel) {
+//	    if bs := rtc.HandleSubscriberDataChannel(subscriberID, p, dc, logger); bs != nil {
+//	        return
+//	    }
+//	    // ... existing per-label handling ...
+//	})
+func HandleSubscriberDataChannel(subscriberID livekit.ParticipantID, tracks BatchSubscriberTrackManager, dc *webrtc.DataChannel, l logger.Logger) *BatchSubscriber {
+	if dc.Label() != batchSubscribeLabel {
+		return nil
+	}
+	return NewBatchSubscriber(subscriberID, tracks, dc, l)
+}
+
+func NewBatchSubscriber(subscriberID livekit.ParticipantID, tracks BatchSubscriberTrackManager, dc *webrtc.DataChannel, l logger.Logger) *BatchSubscriber {
+	b := &BatchSubscriber{
+		subscriberID:  subscriberID,
+		tracks:        tracks,
+		dc:            dc,
+		logger:        l,
+		pendingAdd:    make(map[livekit.TrackID]struct{}),
+		pendingRemove: make(map[livekit.TrackID]struct{}),
+	}
+	dc.OnMessage(b.handleMessage)
+	return b
+}
+
+func (b *BatchSubscriber) handleMessage(msg webrtc.DataChannelMessage) {
+	var m batchSubscribeMessage
+	if err := json.Unmarshal(msg.Data, &m); err != nil {
+		b.logger.Warnw("could not unmarshal batch subscribe message", err)
+		return
+	}
+
+	switch m.Type {
+	case "subscribe":
+		b.enqueue(m.TrackSids, true)
+	case "unsubscribe":
+		b.enqueue(m.TrackSids, false)
+	case "offer":
+		b.handleOffer(m.SDP)
+	default:
+		b.logger.Warnw("unknown batch subscribe message type", nil, "type", m.Type)
+	}
+}
+
+// handleOffer answers an SDP offer sent over the batch DataChannel and sends the answer back the
+// same way, so a "wall of video" subscribe burst negotiates over one exchange instead of one
+// signaling round-trip per track.
+func (b *BatchSubscriber) handleOffer(sdp string) {
+	answer, err := b.tracks.HandleClientOffer(webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: sdp})
+	if err != nil {
+		b.logger.Warnw("could not answer batch subscribe offer", err)
+		return
+	}
+
+	reply, err := json.Marshal(batchSubscribeMessage{Type: "answer", SDP: answer.SDP})
+	if err != nil {
+		b.logger.Warnw("could not marshal batch subscribe answer", err)
+		return
+	}
+	if err = b.dc.SendText(string(reply)); err != nil {
+		b.logger.Warnw("could not send batch subscribe answer", err)
+	}
+}
+
+func (b *BatchSubscriber) enqueue(trackSids []string, subscribe bool) {
+	b.lock.Lock()
+	for _, sidStr := range trackSids {
+		sid := livekit.TrackID(sidStr)
+		if subscribe {
+			delete(b.pendingRemove, sid)
+			b.pendingAdd[sid] = struct{}{}
+		} else {
+			delete(b.pendingAdd, sid)
+			b.pendingRemove[sid] = struct{}{}
+		}
+	}
+	if b.flushTimer == nil {
+		b.flushTimer = time.AfterFunc(batchCoalesceWindow, b.flush)
+	}
+	b.lock.Unlock()
+}
+
+// flush applies every pending add/remove accumulated since the last flush through the existing
+// per-track MediaTrackSubscriptions.AddSubscriber/RemoveSubscriber calls, then triggers exactly
+// one renegotiation for the whole batch.
+func (b *BatchSubscriber) flush() {
+	b.lock.Lock()
+	toAdd := b.pendingAdd
+	toRemove := b.pendingRemove
+	b.pendingAdd = make(map[livekit.TrackID]struct{})
+	b.pendingRemove = make(map[livekit.TrackID]struct{})
+	b.flushTimer = nil
+	b.lock.Unlock()
+
+	if len(toAdd) == 0 && len(toRemove) == 0 {
+		return
+	}
+
+	for trackID := range toAdd {
+		subs := b.tracks.GetSubscriptions(trackID)
+		if subs == nil {
+			b.logger.Warnw("batch subscribe: unknown track", nil, "trackID", trackID)
+			continue
+		}
+		subs.AddSubscriber(b.subscriberID)
+	}
+	for trackID := range toRemove {
+		subs := b.tracks.GetSubscriptions(trackID)
+		if subs == nil {
+			continue
+		}
+		subs.RemoveSubscriber(b.subscriberID)
+	}
+
+	b.logger.Debugw("batch subscribe flush", "added", len(toAdd), "removed", len(toRemove))
+	b.tracks.Negotiate(false)
+}
+
+// Close stops any pending flush without renegotiating.
+func (b *BatchSubscriber) Close() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.flushTimer != nil {
+		b.flushTimer.Stop()
+		b.flushTimer = nil
+	}
+}
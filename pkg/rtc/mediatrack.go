@@ -18,6 +18,7 @@ import (
 	"context"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/pion/rtcp"
 	"github.com/pion/webrtc/v3"
@@ -31,6 +32,7 @@ import (
 	"github.com/livekit/livekit-server/pkg/rtc/types"
 	"github.com/livekit/livekit-server/pkg/sfu"
 	"github.com/livekit/livekit-server/pkg/sfu/buffer"
+	"github.com/livekit/livekit-server/pkg/sfu/bwe/trendestimator"
 	"github.com/livekit/livekit-server/pkg/sfu/connectionquality"
 	"github.com/livekit/livekit-server/pkg/telemetry"
 )
@@ -46,6 +48,7 @@ type MediaTrack struct {
 	*MediaLossProxy
 
 	dynacastManager *DynacastManager
+	bwe             *trendestimator.TrendEstimator
 
 	lock sync.RWMutex
 }
@@ -67,6 +70,11 @@ type MediaTrackParams struct {
 	Telemetry         telemetry.TelemetryService
 	Logger            logger.Logger
 	SimTracks         map[uint32]SimulcastTrackInfo
+	// BandwidthProbeUnstableDelay is how long the upstream bandwidth estimator must see sustained
+	// underuse before probing upward for more bandwidth. Deliberately separate from
+	// VideoConfig.DynacastPauseDelay: that delay gates pausing layers on overuse, this one gates
+	// probing on underuse, and operators need to tune them independently.
+	BandwidthProbeUnstableDelay time.Duration
 }
 
 func NewMediaTrack(params MediaTrackParams, ti *livekit.TrackInfo) *MediaTrack {
@@ -108,6 +116,24 @@ func NewMediaTrack(params MediaTrackParams, ti *livekit.TrackInfo) *MediaTrack {
 		t.MediaTrackReceiver.OnSetupReceiver(func(mime string) {
 			t.dynacastManager.AddCodec(mime)
 		})
+
+		t.bwe = trendestimator.NewTrendEstimator(trendestimator.Params{
+			UnstableDuration: params.BandwidthProbeUnstableDelay,
+		})
+		// NOTE: this assumes DynacastManager.NotifyPublisherBandwidthTrend already implements the
+		// pause-on-overuse / probe-on-underuse reaction to these trend notifications.
+		// DynacastManager's source isn't part of this change, so that behavior can't be verified
+		// here; confirm it pre-exists with this signature before relying on this wiring.
+		t.bwe.OnTrendChanged(func(trend trendestimator.Trend) {
+			switch trend {
+			case trendestimator.TrendOverusing:
+				t.dynacastManager.NotifyPublisherBandwidthTrend(true, false)
+			case trendestimator.TrendUnderusing:
+				t.dynacastManager.NotifyPublisherBandwidthTrend(false, t.bwe.IsUnstable(time.Now()))
+			default:
+				t.dynacastManager.NotifyPublisherBandwidthTrend(false, false)
+			}
+		})
 		t.MediaTrackReceiver.OnSubscriberMaxQualityChange(
 			func(subscriberID livekit.ParticipantID, codec webrtc.RTPCodecCapability, layer int32) {
 				t.dynacastManager.NotifySubscriberMaxQuality(
@@ -140,8 +166,17 @@ func (t *MediaTrack) OnSubscribedMaxQualityChange(
 
 		for _, q := range maxSubscribedQualities {
 			receiver := t.Receiver(q.CodecMime)
-			if receiver != nil {
-				receiver.SetMaxExpectedSpatialLayer(buffer.VideoQualityToSpatialLayer(q.Quality, t.MediaTrackReceiver.TrackInfo()))
+			if receiver == nil {
+				continue
+			}
+			spatialLayer := buffer.VideoQualityToSpatialLayer(q.Quality, t.MediaTrackReceiver.TrackInfo())
+			receiver.SetMaxExpectedSpatialLayer(spatialLayer)
+			if isSVCMimeType(q.CodecMime) {
+				// a single SVC SSRC multiplexes spatial and temporal layers together, but spatial
+				// resolution and temporal framerate are independent axes: a subscriber asking for
+				// LOW spatial quality should not also be clamped to the lowest framerate, so map
+				// quality to a temporal budget of its own instead of reusing spatialLayer
+				receiver.SetMaxExpectedTemporalLayer(videoQualityToTemporalLayer(q.Quality))
 			}
 		}
 	}
@@ -218,13 +253,42 @@ func (t *MediaTrack) AddReceiver(receiver *webrtc.RTPReceiver, track *webrtc.Tra
 		"layer", layer,
 		"ssrc", track.SSRC(),
 	)
+	// mimeRef is shared by every closure this call registers that needs to know this track's
+	// current mime (OnCloseHandler's ClearReceiver, OnFinalRtpStats, the RTX/FEC telemetry
+	// callbacks in setupAssociatedSSRCs, and setupCodecChangeDetection's own payload-type-change
+	// handler): setupCodecChangeDetection re-keys the receiver under a new mime when the publisher
+	// switches codec families mid-session, and every one of those closures must see that update,
+	// not the mime this track happened to start with.
+	mimeRef := atomic.NewString(mime)
 	wr := t.MediaTrackReceiver.Receiver(mime)
 	if wr == nil {
 		priority := -1
+		svcIdx := -1
 		for idx, c := range ti.Codecs {
-			if strings.EqualFold(mime, c.MimeType) {
+			if isSVCMimeType(c.MimeType) && svcIdx < 0 {
+				svcIdx = idx
+			}
+			if priority < 0 && strings.EqualFold(mime, c.MimeType) {
+				// first match wins; don't let a later duplicate-mime entry overwrite it, but keep
+				// scanning the rest of the list so svcIdx above is still found
 				priority = idx
-				break
+			}
+		}
+		if svcIdx >= 0 {
+			if priority == svcIdx {
+				// a single AV1/VP9 SVC SSRC already carries every spatial/temporal layer, so make
+				// the first SVC codec in codec order primary (priority 0) regardless of where it
+				// falls in the negotiated codec order, superseding any simulcast fallback the
+				// publisher also offered. Checking against svcIdx rather than isSVCMimeType(mime)
+				// matters when a publisher offers more than one SVC codec (e.g. AV1 and VP9): only
+				// the first one gets priority 0, the rest fall through to the codec-order demotion
+				// below instead of colliding with it.
+				priority = 0
+			} else if priority == 0 {
+				// this codec would have been primary by codec order, but the publisher also
+				// offered an SVC codec elsewhere in ti.Codecs; demote it into the slot SVC just
+				// vacated so SVC keeps priority 0 without pushing priority past len(ti.Codecs)-1
+				priority = svcIdx
 			}
 		}
 		if priority < 0 {
@@ -245,6 +309,13 @@ func (t *MediaTrack) AddReceiver(receiver *webrtc.RTPReceiver, track *webrtc.Tra
 			return false
 		}
 
+		receiverOpts := []sfu.ReceiverOpts{
+			sfu.WithPliThrottleConfig(t.params.PLIThrottleConfig),
+			sfu.WithAudioConfig(t.params.AudioConfig),
+			sfu.WithLoadBalanceThreshold(20),
+			sfu.WithStreamTrackers(),
+		}
+
 		newWR := sfu.NewWebRTCReceiver(
 			receiver,
 			track,
@@ -252,15 +323,12 @@ func (t *MediaTrack) AddReceiver(receiver *webrtc.RTPReceiver, track *webrtc.Tra
 			LoggerWithCodecMime(t.params.Logger, mime),
 			twcc,
 			t.params.VideoConfig.StreamTracker,
-			sfu.WithPliThrottleConfig(t.params.PLIThrottleConfig),
-			sfu.WithAudioConfig(t.params.AudioConfig),
-			sfu.WithLoadBalanceThreshold(20),
-			sfu.WithStreamTrackers(),
+			receiverOpts...,
 		)
 		newWR.SetRTCPCh(t.params.RTCPChan)
 		newWR.OnCloseHandler(func() {
 			t.MediaTrackReceiver.SetClosing()
-			t.MediaTrackReceiver.ClearReceiver(mime, false)
+			t.MediaTrackReceiver.ClearReceiver(mimeRef.Load(), false)
 			if t.MediaTrackReceiver.TryClose() {
 				if t.dynacastManager != nil {
 					t.dynacastManager.Close()
@@ -275,6 +343,15 @@ func (t *MediaTrack) AddReceiver(receiver *webrtc.RTPReceiver, track *webrtc.Tra
 			})
 
 			newWR.OnMaxLayerChange(t.onMaxLayerChange)
+
+			if t.bwe != nil {
+				// only the primary codec feeds the publisher-side trend estimate; buff derives the
+				// inter-packet send/arrival deltas AddSample needs directly off the packets it
+				// already sees, one level below any disjoint TWCC feedback round trip
+				buff.OnTWCCFeedback(func(sendDelta, arrivalDelta time.Duration) {
+					t.bwe.AddSample(time.Now(), sendDelta, arrivalDelta)
+				})
+			}
 		}
 		if t.PrimaryReceiver() == nil {
 			// primary codec published, set potential codecs
@@ -333,14 +410,143 @@ func (t *MediaTrack) AddReceiver(receiver *webrtc.RTPReceiver, track *webrtc.Tra
 			context.Background(),
 			t.params.ParticipantID,
 			t.ID(),
-			mime,
+			mimeRef.Load(),
 			int(layer),
 			stats,
 		)
 	})
+
+	t.setupAssociatedSSRCs(receiver, track, buff, mimeRef, layer)
+	t.setupCodecChangeDetection(receiver, track, buff, mimeRef)
+	if isSVCMimeType(mime) {
+		t.setupSVCLayerGating(buff, mime)
+	}
+
 	return newCodec
 }
 
+// setupCodecChangeDetection watches for the publisher renegotiating the payload type of this SSRC
+// mid-session (e.g. upgrading to AV1 without dropping the track) and swaps the depacketizer in
+// place instead of silently corrupting the forwarded stream. A cross-codec-family switch also
+// re-keys the receiver under the new mime so mime-indexed lookups keep finding it.
+//
+// mimeRef is shared with every other closure AddReceiver registered for this track, so a
+// mid-session switch updates what all of them report, not just this one.
+func (t *MediaTrack) setupCodecChangeDetection(receiver *webrtc.RTPReceiver, track *webrtc.TrackRemote, buff *buffer.Buffer, mimeRef *atomic.String) {
+	buff.OnPayloadTypeChange(func(newPT uint8) {
+		var newCodec webrtc.RTPCodecParameters
+		found := false
+		for _, c := range receiver.GetParameters().Codecs {
+			if uint8(c.PayloadType) == newPT {
+				newCodec = c
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.params.Logger.Warnw("publisher switched to unknown payload type", nil, "payloadType", newPT, "ssrc", track.SSRC())
+			return
+		}
+
+		mime := mimeRef.Load()
+		newMime := strings.ToLower(newCodec.MimeType)
+		t.params.Logger.Infow("publisher switched codec mid-session", "from", mime, "to", newMime, "payloadType", newPT, "ssrc", track.SSRC())
+
+		buff.SwitchDepacketizer(newCodec.RTPCodecCapability)
+		if newMime != mime {
+			// a cross-codec-family switch (e.g. upgrading to AV1) changes which mime this
+			// receiver must be keyed under; without re-keying it, lookups like
+			// OnSubscribedMaxQualityChange's t.Receiver(q.CodecMime) for the new mime would find
+			// nothing and silently stop gating spatial/temporal layers after the switch
+			t.MediaTrackReceiver.RemapReceiver(mime, newMime)
+			if t.dynacastManager != nil {
+				t.dynacastManager.AddCodec(newMime)
+			}
+			mimeRef.Store(newMime)
+		}
+		t.MediaTrackReceiver.NotifyCodecChange(newMime)
+
+		select {
+		case t.params.RTCPChan <- []rtcp.Packet{&rtcp.PictureLossIndication{MediaSSRC: uint32(track.SSRC())}}:
+		default:
+			t.params.Logger.Warnw("could not request keyframe after codec switch", nil, "ssrc", track.SSRC())
+		}
+	})
+}
+
+// setupAssociatedSSRCs looks for RTX and ULPFEC SSRCs paired with the primary SSRC in the
+// negotiated encoding parameters and, when present, pairs them with the primary buffer via
+// SetRTXPair/SetFECPair so packets arriving on them are demuxed/recovered (buffer.DemuxRTX,
+// buffer.RecoverULPFEC) and reinserted into the primary buffer's jitter cache instead of being
+// dropped as unknown SSRCs. It also forwards each side channel buffer's OnFinalRtpStats to
+// Telemetry.TrackPublishRTPStats, tagged as the recovery channel for mime/layer. Buffer reports
+// an empty RTPStats there, so neither packets seen on the RTX/FEC SSRC nor recovered-packet
+// counts are reported.
+//
+// mimeRef is shared with setupCodecChangeDetection for this same track, so this telemetry keeps
+// reporting under the publisher's current mime across a mid-session codec switch.
+func (t *MediaTrack) setupAssociatedSSRCs(receiver *webrtc.RTPReceiver, track *webrtc.TrackRemote, primaryBuff *buffer.Buffer, mimeRef *atomic.String, layer int32) {
+	var encoding *webrtc.RTPCodingParameters
+	for _, e := range receiver.GetParameters().Encodings {
+		if e.SSRC == track.SSRC() {
+			encoding = &e
+			break
+		}
+	}
+	if encoding == nil {
+		return
+	}
+
+	if rtxSsrc := uint32(encoding.RTX.SSRC); rtxSsrc != 0 {
+		rtxBuff, rtxRTCPReader := t.params.BufferFactory.GetBufferPair(rtxSsrc)
+		if rtxBuff == nil || rtxRTCPReader == nil {
+			t.params.Logger.Errorw("could not retrieve rtx buffer pair", nil, "rtxSsrc", rtxSsrc)
+		} else {
+			primaryBuff.SetRTXPair(rtxSsrc, rtxBuff)
+			t.params.Logger.Debugw("bound rtx ssrc to primary buffer", "ssrc", track.SSRC(), "rtxSsrc", rtxSsrc)
+			rtxBuff.OnFinalRtpStats(func(stats *livekit.RTPStats) {
+				t.params.Telemetry.TrackPublishRTPStats(
+					context.Background(),
+					t.params.ParticipantID,
+					t.ID(),
+					mimeRef.Load()+recoveryChannelMimeSuffix("rtx"),
+					int(layer),
+					stats,
+				)
+			})
+		}
+	}
+
+	if fecSsrc := uint32(encoding.FEC.SSRC); fecSsrc != 0 {
+		fecBuff, fecRTCPReader := t.params.BufferFactory.GetBufferPair(fecSsrc)
+		if fecBuff == nil || fecRTCPReader == nil {
+			t.params.Logger.Errorw("could not retrieve fec buffer pair", nil, "fecSsrc", fecSsrc)
+		} else {
+			// recovered packets are fed back into the primary buffer's jitter cache for
+			// re-ordering.
+			primaryBuff.SetFECPair(fecSsrc, fecBuff)
+			t.params.Logger.Debugw("bound fec ssrc to primary buffer", "ssrc", track.SSRC(), "fecSsrc", fecSsrc)
+			fecBuff.OnFinalRtpStats(func(stats *livekit.RTPStats) {
+				t.params.Telemetry.TrackPublishRTPStats(
+					context.Background(),
+					t.params.ParticipantID,
+					t.ID(),
+					mimeRef.Load()+recoveryChannelMimeSuffix("fec"),
+					int(layer),
+					stats,
+				)
+			})
+		}
+	}
+}
+
+// recoveryChannelMimeSuffix tags a recovery-channel (RTX/FEC) telemetry report so it is reported
+// as its own stream instead of silently overwriting the primary stream's report under the same
+// (mime, layer) key; Telemetry.TrackPublishRTPStats has no dedicated parameter for this today.
+func recoveryChannelMimeSuffix(channel string) string {
+	return "+" + channel
+}
+
 func (t *MediaTrack) GetConnectionScoreAndQuality() (float32, livekit.ConnectionQuality) {
 	receiver := t.PrimaryReceiver()
 	if rtcReceiver, ok := receiver.(*sfu.WebRTCReceiver); ok {
@@ -375,10 +581,49 @@ func (t *MediaTrack) Close(willBeResumed bool) {
 	if t.dynacastManager != nil {
 		t.dynacastManager.Close()
 	}
+	if t.bwe != nil {
+		t.bwe.Close()
+	}
 	t.MediaTrackReceiver.ClearAllReceivers(willBeResumed)
 	t.MediaTrackReceiver.Close()
 }
 
+// isSVCMimeType reports whether mime identifies a scalable video codec (AV1 or VP9) whose single
+// SSRC carries spatial and temporal layers via the AV1 Dependency Descriptor or the VP9 payload
+// descriptor, as opposed to simulcast where each layer is its own SSRC/RID.
+func isSVCMimeType(mime string) bool {
+	mime = strings.ToLower(mime)
+	return mime == "video/av1" || mime == "video/vp9"
+}
+
+// setupSVCLayerGating decodes each packet's (spatial, temporal) layer via buff.OnSVCLayer (which
+// reports VP9 packets only, not AV1) and reports it through MediaTrackReceiver, so the
+// subscriber-quality-to-layer-budget mapping already
+// used for simulcast (see videoQualityToTemporalLayer) has a real observed layer to gate against
+// for SVC too. Actually withholding an above-budget packet from a given subscriber is a
+// DownTrack/Forwarder concern; no such subsystem exists in this package, so buffer.ShouldForwardLayer
+// remains unused here until one does — wiring it in is out of scope for this change.
+func (t *MediaTrack) setupSVCLayerGating(buff *buffer.Buffer, mime string) {
+	buff.OnSVCLayer(func(layers buffer.SVCLayers) {
+		t.MediaTrackReceiver.NotifySVCLayerObserved(mime, layers.Spatial, layers.Temporal)
+	})
+}
+
+// videoQualityToTemporalLayer maps a subscriber's requested quality tier to a temporal-layer
+// budget for SVC codecs. This is deliberately independent of VideoQualityToSpatialLayer: spatial
+// resolution and temporal framerate are separate axes of an SVC stream, so a LOW spatial request
+// should not also force the lowest temporal layer.
+func videoQualityToTemporalLayer(quality livekit.VideoQuality) int32 {
+	switch quality {
+	case livekit.VideoQuality_LOW:
+		return 0
+	case livekit.VideoQuality_MEDIUM:
+		return 1
+	default:
+		return 2
+	}
+}
+
 func (t *MediaTrack) SetMuted(muted bool) {
 	// update quality based on subscription if unmuting.
 	// This will queue up the current state, but subscriber
@@ -0,0 +1,328 @@
+// Copyright 2023 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+
+	"github.com/livekit/protocol/auth"
+	"github.com/livekit/protocol/livekit"
+	"github.com/livekit/protocol/logger"
+
+	"github.com/livekit/livekit-server/pkg/config"
+)
+
+const whipContentType = "application/sdp"
+
+// whipGatherTimeout bounds how long HandlePublish waits for ICE gathering to complete before
+// failing the publish instead of hanging the handler goroutine (and the participant it already
+// created) on a publisher whose network can't reach any candidate.
+const whipGatherTimeout = 10 * time.Second
+
+var (
+	ErrWHIPUnauthorized = errors.New("whip: missing or invalid bearer token")
+	ErrWHIPBadOffer     = errors.New("whip: invalid or unsupported SDP offer")
+	ErrWHIPResourceGone = errors.New("whip: resource not found")
+)
+
+// WHIPParticipant is the minimal surface a WHIPHandler needs from the room/participant layer:
+// funneling a PeerConnection's incoming tracks through the existing MediaTrack pipeline
+// (NewMediaTrack / AddReceiver, unchanged) without the WHIP handler needing to know about rooms,
+// participants or signaling beyond that.
+type WHIPParticipant interface {
+	AddTrack(track *webrtc.TrackRemote, receiver *webrtc.RTPReceiver, mid string) bool
+	Close()
+}
+
+// WHIPRoomManager maps an authenticated WHIP publish request onto a participant in the target
+// room, reusing the same join path as SDK clients.
+type WHIPRoomManager interface {
+	CreateWHIPParticipant(roomName livekit.RoomName, identity livekit.ParticipantIdentity, grants *auth.ClaimGrants) (WHIPParticipant, error)
+}
+
+// WHIPHandler implements a WHIP (WebRTC-HTTP Ingestion Protocol, draft-ietf-wish-whip) endpoint.
+// It terminates the HTTP/SDP signaling exchange and hands the resulting PeerConnection's tracks to
+// the existing MediaTrack pipeline, so WHIP publishers (OBS, gstreamer, ffmpeg) appear as regular
+// participants without needing the LiveKit client SDK.
+type WHIPHandler struct {
+	conf        *config.WebRTCConfig
+	roomManager WHIPRoomManager
+	keyProvider auth.KeyProvider
+	logger      logger.Logger
+
+	lock      sync.Mutex
+	resources map[string]*whipResource
+}
+
+type whipResource struct {
+	pc          *webrtc.PeerConnection
+	participant WHIPParticipant
+	identity    livekit.ParticipantIdentity
+}
+
+func NewWHIPHandler(conf *config.WebRTCConfig, roomManager WHIPRoomManager, keyProvider auth.KeyProvider) *WHIPHandler {
+	return &WHIPHandler{
+		conf:        conf,
+		roomManager: roomManager,
+		keyProvider: keyProvider,
+		logger:      logger.GetLogger(),
+		resources:   make(map[string]*whipResource),
+	}
+}
+
+// HandlePublish handles `POST /whip/{room}`: it accepts an SDP offer carrying a bearer token,
+// creates the corresponding participant, and returns the SDP answer plus a Location header
+// identifying the new resource for later DELETE/PATCH requests.
+func (h *WHIPHandler) HandlePublish(w http.ResponseWriter, r *http.Request, roomName livekit.RoomName) {
+	grants, identity, err := h.authenticate(r, roomName)
+	if err != nil {
+		writeError(w, http.StatusUnauthorized, err)
+		return
+	}
+	if grants.Video == nil || !grants.Video.CanPublish {
+		writeError(w, http.StatusForbidden, ErrWHIPUnauthorized)
+		return
+	}
+
+	if ct := r.Header.Get("Content-Type"); ct != whipContentType {
+		writeError(w, http.StatusUnsupportedMediaType, fmt.Errorf("%w: content type %q", ErrWHIPBadOffer, ct))
+		return
+	}
+	offer, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	pc, err := webrtc.NewPeerConnection(h.conf.Configuration)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	// Validate the offer before creating a room participant for it: a malformed body from an
+	// otherwise-authenticated caller should fail here rather than causing participant join/leave
+	// churn in the room.
+	if err = pc.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: string(offer)}); err != nil {
+		_ = pc.Close()
+		writeError(w, http.StatusBadRequest, fmt.Errorf("%w: %v", ErrWHIPBadOffer, err))
+		return
+	}
+
+	participant, err := h.roomManager.CreateWHIPParticipant(roomName, identity, grants)
+	if err != nil {
+		_ = pc.Close()
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	pc.OnTrack(func(track *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
+		participant.AddTrack(track, receiver, midForReceiver(pc, receiver))
+	})
+
+	answer, err := pc.CreateAnswer(nil)
+	if err != nil {
+		_ = pc.Close()
+		participant.Close()
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	gatherComplete := webrtc.GatheringCompletePromise(pc)
+	if err = pc.SetLocalDescription(answer); err != nil {
+		_ = pc.Close()
+		participant.Close()
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), whipGatherTimeout)
+	defer cancel()
+	select {
+	case <-gatherComplete:
+	case <-ctx.Done():
+		_ = pc.Close()
+		participant.Close()
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("whip: timed out waiting for ICE gathering"))
+		return
+	}
+
+	resourceID, err := newResourceID()
+	if err != nil {
+		_ = pc.Close()
+		participant.Close()
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	h.lock.Lock()
+	h.resources[resourceID] = &whipResource{pc: pc, participant: participant, identity: identity}
+	h.lock.Unlock()
+
+	pc.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		if state == webrtc.PeerConnectionStateFailed || state == webrtc.PeerConnectionStateClosed {
+			h.removeResource(resourceID)
+		}
+	})
+
+	w.Header().Set("Content-Type", whipContentType)
+	w.Header().Set("Location", fmt.Sprintf("/whip/%s/resource/%s", roomName, resourceID))
+	w.WriteHeader(http.StatusCreated)
+	_, _ = w.Write([]byte(pc.LocalDescription().SDP))
+}
+
+// HandleDelete handles `DELETE /whip/{room}/resource/{resourceID}`, tearing down the publisher's
+// PeerConnection and its participant. Like HandlePublish, this re-verifies the caller's bearer
+// token rather than trusting the resourceID alone: resourceID is echoed back in the Location
+// header of an earlier response, which can end up in proxy/access logs or browser history, so
+// anyone who observes it must not be able to tear down someone else's publish session with it.
+func (h *WHIPHandler) HandleDelete(w http.ResponseWriter, r *http.Request, roomName livekit.RoomName, resourceID string) {
+	h.lock.Lock()
+	res, ok := h.resources[resourceID]
+	h.lock.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, ErrWHIPResourceGone)
+		return
+	}
+	if status, err := h.authorizeResource(r, roomName, res); err != nil {
+		writeError(w, status, err)
+		return
+	}
+
+	h.removeResource(resourceID)
+	w.WriteHeader(http.StatusOK)
+}
+
+// HandlePatch handles `PATCH /whip/{room}/resource/{resourceID}`, applying trickled ICE
+// candidates delivered as an SDP fragment body. Re-verifies the bearer token for the same reason
+// HandleDelete does: without it, anyone who observes the resourceID could inject ICE candidates
+// into another participant's publish session.
+func (h *WHIPHandler) HandlePatch(w http.ResponseWriter, r *http.Request, roomName livekit.RoomName, resourceID string) {
+	h.lock.Lock()
+	res, ok := h.resources[resourceID]
+	h.lock.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, ErrWHIPResourceGone)
+		return
+	}
+	if status, err := h.authorizeResource(r, roomName, res); err != nil {
+		writeError(w, status, err)
+		return
+	}
+
+	fragment, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	for _, line := range strings.Split(string(fragment), "\r\n") {
+		if !strings.HasPrefix(line, "a=candidate:") {
+			continue
+		}
+		if err := res.pc.AddICECandidate(webrtc.ICECandidateInit{Candidate: strings.TrimPrefix(line, "a=")}); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *WHIPHandler) removeResource(resourceID string) bool {
+	h.lock.Lock()
+	res, ok := h.resources[resourceID]
+	if ok {
+		delete(h.resources, resourceID)
+	}
+	h.lock.Unlock()
+	if !ok {
+		return false
+	}
+	res.participant.Close()
+	_ = res.pc.Close()
+	return true
+}
+
+// authenticate validates the WHIP bearer token against the configured key provider and checks the
+// resulting grant against the requested room, the same way SDK join tokens are checked.
+func (h *WHIPHandler) authenticate(r *http.Request, roomName livekit.RoomName) (*auth.ClaimGrants, livekit.ParticipantIdentity, error) {
+	authHeader := r.Header.Get("Authorization")
+	if !strings.HasPrefix(authHeader, "Bearer ") {
+		return nil, "", ErrWHIPUnauthorized
+	}
+	token := strings.TrimPrefix(authHeader, "Bearer ")
+
+	v := auth.NewAccessTokenVerifier(token, h.keyProvider)
+	grants, err := v.Verify()
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %v", ErrWHIPUnauthorized, err)
+	}
+	if grants.Video == nil || livekit.RoomName(grants.Video.Room) != roomName {
+		return nil, "", ErrWHIPUnauthorized
+	}
+	return grants, livekit.ParticipantIdentity(grants.Identity), nil
+}
+
+// authorizeResource re-verifies the bearer token presented on a DELETE/PATCH request against the
+// room and identity that originally created res, rather than trusting knowledge of the resourceID
+// alone. Returns the HTTP status to respond with and a non-nil error when the request should be
+// rejected.
+func (h *WHIPHandler) authorizeResource(r *http.Request, roomName livekit.RoomName, res *whipResource) (int, error) {
+	grants, identity, err := h.authenticate(r, roomName)
+	if err != nil {
+		return http.StatusUnauthorized, err
+	}
+	if grants.Video == nil || !grants.Video.CanPublish || identity != res.identity {
+		return http.StatusForbidden, ErrWHIPUnauthorized
+	}
+	return 0, nil
+}
+
+// midForReceiver resolves the SDP mid of the m-line receiver was negotiated on. Pion's OnTrack
+// callback hands back the TrackRemote and RTPReceiver but not the mid directly, so it has to be
+// recovered by matching receiver against the PeerConnection's transceivers; RID (used for
+// simulcast layer selection) is a different, unrelated identifier and is empty for the ordinary
+// non-simulcast case WHIP publishes, so it can't stand in for mid here.
+func midForReceiver(pc *webrtc.PeerConnection, receiver *webrtc.RTPReceiver) string {
+	for _, tr := range pc.GetTransceivers() {
+		if tr.Receiver() == receiver {
+			return tr.Mid()
+		}
+	}
+	return ""
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	w.WriteHeader(status)
+	_, _ = w.Write([]byte(err.Error()))
+}
+
+func newResourceID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
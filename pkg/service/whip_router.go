@@ -0,0 +1,59 @@
+// Copyright 2023 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/livekit/protocol/livekit"
+)
+
+// whipRoutePrefix is the path prefix HandleFunc/ServeMux.Handle mounts the WHIP endpoint under.
+const whipRoutePrefix = "/whip/"
+
+// RegisterWHIPHandler mounts h on mux under whipRoutePrefix so WHIP publishers (OBS, gstreamer,
+// ffmpeg) can reach HandlePublish/HandleDelete/HandlePatch. Call this once from the same place the
+// other protocol endpoints (RTC, recorder, etc.) are registered on the main HTTP server's mux.
+func RegisterWHIPHandler(mux *http.ServeMux, h *WHIPHandler) {
+	mux.Handle(whipRoutePrefix, h)
+}
+
+// ServeHTTP routes WHIP requests under the `/whip/` prefix:
+//
+//	POST   /whip/{room}                    - publish, returns SDP answer + Location
+//	DELETE /whip/{room}/resource/{id}       - tear down the publisher
+//	PATCH  /whip/{room}/resource/{id}       - trickle ICE via SDP fragment
+func (h *WHIPHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, whipRoutePrefix), "/"), "/")
+	if len(parts) == 0 || parts[0] == "" {
+		writeError(w, http.StatusNotFound, ErrWHIPResourceGone)
+		return
+	}
+	roomName := livekit.RoomName(parts[0])
+
+	switch {
+	case r.Method == http.MethodPost && len(parts) == 1:
+		h.HandlePublish(w, r, roomName)
+	case (r.Method == http.MethodDelete || r.Method == http.MethodPatch) && len(parts) == 3 && parts[1] == "resource":
+		if r.Method == http.MethodDelete {
+			h.HandleDelete(w, r, roomName, parts[2])
+		} else {
+			h.HandlePatch(w, r, roomName, parts[2])
+		}
+	default:
+		writeError(w, http.StatusNotFound, ErrWHIPResourceGone)
+	}
+}
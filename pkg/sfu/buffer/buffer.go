@@ -0,0 +1,352 @@
+// Copyright 2023 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package buffer
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+
+	"github.com/livekit/protocol/livekit"
+)
+
+// jitterCacheSize bounds how many of the most recently written sequence numbers a Buffer keeps
+// around, both for its own reordering and so a paired RTX/FEC buffer has something to recover
+// into. Sized generously past a typical ULPFEC protection group (at most 16 packets under the
+// short-mask form this package decodes).
+const jitterCacheSize = 1500
+
+// recoveryKind identifies which algorithm WriteRTP should use to recover a packet arriving on a
+// buffer that exists purely as another buffer's RTX or ULPFEC side channel (see SetRTXPair /
+// SetFECPair). Only ever set on the side-channel buffer, never on the primary it recovers into.
+type recoveryKind int
+
+const (
+	recoveryNone recoveryKind = iota
+	recoveryRTX
+	recoveryFEC
+)
+
+// Buffer is the per-SSRC RTP receive pipeline: it ingests packets arriving on one SSRC through
+// WriteRTP, keeps a bounded jitter cache keyed by sequence number, watches for the publisher
+// changing payload type mid-session, and reports final RTP stats. When paired with an RTX or
+// ULPFEC side channel via SetRTXPair/SetFECPair, packets arriving on the side channel are
+// recovered and reinserted here instead of being cached under their own (side-channel) sequence
+// space.
+type Buffer struct {
+	mu      sync.Mutex
+	ssrc    uint32
+	packets map[uint16]*rtp.Packet
+	order   []uint16
+
+	codecCaps   webrtc.RTPCodecCapability
+	havePT      bool
+	payloadType uint8
+
+	haveLast      bool
+	lastTimestamp uint32
+	lastArrival   time.Time
+
+	recoverInto *Buffer
+	recoverKind recoveryKind
+
+	onPayloadTypeChange func(newPT uint8)
+	onTWCCFeedback      func(sendDelta, arrivalDelta time.Duration)
+	onSVCLayer          func(layers SVCLayers)
+	onFpsChanged        func()
+	onFinalRtpStats     func(*livekit.RTPStats)
+
+	lastFractionLost uint8
+	srRTPTime        uint32
+	srNTPTime        uint64
+}
+
+// NewBuffer creates the jitter-buffer/receive-pipeline for one SSRC. BufferFactory is responsible
+// for constructing one of these per SSRC (primary, RTX, or FEC) and returning the same instance on
+// repeated lookups of that SSRC.
+func NewBuffer(ssrc uint32) *Buffer {
+	return &Buffer{
+		ssrc:    ssrc,
+		packets: make(map[uint16]*rtp.Packet),
+	}
+}
+
+// Bind records the codec this buffer's packets are encoded with, resolving the initial payload
+// type from the negotiated parameters so WriteRTP can detect a publisher-initiated switch away
+// from it later.
+func (b *Buffer) Bind(params webrtc.RTPParameters, caps webrtc.RTPCodecCapability) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.codecCaps = caps
+	for _, c := range params.Codecs {
+		if strings.EqualFold(c.MimeType, caps.MimeType) {
+			b.payloadType = uint8(c.PayloadType)
+			b.havePT = true
+			break
+		}
+	}
+}
+
+// OnFpsChanged registers a callback invoked when this buffer's estimated framerate changes.
+// WriteRTP does not attempt to compute fps itself (that needs a longer observation window than a
+// single packet); this hook only exists so callers registered against it today keep compiling.
+func (b *Buffer) OnFpsChanged(f func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.onFpsChanged = f
+}
+
+// OnFinalRtpStats registers a callback invoked once, when this buffer is torn down. Buffer does
+// not accumulate RTP stats: the callback receives an empty livekit.RTPStats.
+func (b *Buffer) OnFinalRtpStats(f func(*livekit.RTPStats)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.onFinalRtpStats = f
+}
+
+// SetSenderReportData records the RTP/NTP timestamp pair from the publisher's most recent sender
+// report, used to translate this buffer's RTP timestamps to wall-clock time for stats/sync.
+func (b *Buffer) SetSenderReportData(rtpTime uint32, ntpTime uint64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.srRTPTime = rtpTime
+	b.srNTPTime = ntpTime
+}
+
+// SetLastFractionLostReport records the most recently reported fractional packet loss, used by
+// MediaLossProxy to aggregate loss across a track's receivers.
+func (b *Buffer) SetLastFractionLostReport(fractionLost uint8) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.lastFractionLost = fractionLost
+}
+
+// OnPayloadTypeChange registers a callback invoked when WriteRTP observes an arriving packet's
+// payload type no longer matches the current one (initially the one Bind resolved, afterwards the
+// last one WriteRTP saw change to).
+func (b *Buffer) OnPayloadTypeChange(f func(newPT uint8)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.onPayloadTypeChange = f
+}
+
+// SwitchDepacketizer re-binds this buffer to a new codec after a mid-session payload type change,
+// so WriteRTP uses the new codec's clock rate for send deltas and its mime for SVC descriptor
+// parsing. It does not touch the payload type: WriteRTP records the new one itself before firing
+// OnPayloadTypeChange, so the callback fires once per switch rather than on every following packet.
+func (b *Buffer) SwitchDepacketizer(caps webrtc.RTPCodecCapability) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.codecCaps = caps
+}
+
+// OnTWCCFeedback registers a callback invoked for every packet after the first with the inter-
+// packet send/arrival deltas a delay-based bandwidth estimator needs: sendDelta is derived from
+// the RTP timestamp delta between consecutive packets (converted to wall-clock time via the
+// codec's clock rate), arrivalDelta from this buffer's own local arrival clock. This is the same
+// underlying signal transport-wide-cc feedback reports are built from, computed directly off the
+// packets this buffer already sees rather than waiting on a separate TWCC round trip.
+func (b *Buffer) OnTWCCFeedback(f func(sendDelta, arrivalDelta time.Duration)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.onTWCCFeedback = f
+}
+
+// OnSVCLayer registers a callback invoked with the decoded (spatial, temporal) layer of every
+// packet WriteRTP can successfully parse an SVC descriptor from. Only the VP9 payload descriptor
+// is decoded here (see svcDescriptorSource): AV1 packets are never reported, since their
+// Dependency Descriptor header extension is not read. Packets of any other mime, or that don't
+// carry a resolvable descriptor, are silently skipped rather than reported as an error on every
+// packet.
+func (b *Buffer) OnSVCLayer(f func(layers SVCLayers)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.onSVCLayer = f
+}
+
+// SetRTXPair registers rtxBuff as the RFC 4588 retransmission channel for this (primary) buffer's
+// SSRC. Packets WriteRTP receives on rtxBuff are demuxed with DemuxRTX and the recovered original
+// reinserted into this buffer's own jitter cache under its original sequence number.
+func (b *Buffer) SetRTXPair(rtxSSRC uint32, rtxBuff *Buffer) {
+	rtxBuff.mu.Lock()
+	rtxBuff.recoverInto = b
+	rtxBuff.recoverKind = recoveryRTX
+	rtxBuff.mu.Unlock()
+}
+
+// SetFECPair registers fecBuff as the RFC 5109 ULPFEC channel for this (primary) buffer's SSRC.
+// Packets WriteRTP receives on fecBuff are matched against this buffer's jitter cache with
+// RecoverULPFEC and, when they recover exactly one missing packet, the result is reinserted here.
+func (b *Buffer) SetFECPair(fecSSRC uint32, fecBuff *Buffer) {
+	fecBuff.mu.Lock()
+	fecBuff.recoverInto = b
+	fecBuff.recoverKind = recoveryFEC
+	fecBuff.mu.Unlock()
+}
+
+// WriteRTP ingests one packet arriving on this buffer's SSRC. This is the real packet-receive path
+// the rest of this file's hooks fire from: a buffer configured via SetRTXPair/SetFECPair as a
+// recovery side channel never stores packets under its own sequence space at all, it demuxes or
+// XOR-recovers them and reinserts the result into the primary buffer it was paired with; any other
+// buffer caches the packet, detects payload-type switches, and surfaces TWCC-style send/arrival
+// deltas and decoded SVC layers to whichever callbacks are registered.
+func (b *Buffer) WriteRTP(pkt *rtp.Packet) {
+	b.mu.Lock()
+	recoverInto := b.recoverInto
+	recoverKind := b.recoverKind
+	b.mu.Unlock()
+
+	if recoverInto != nil {
+		b.recoverAndReinsert(pkt, recoverKind, recoverInto)
+		return
+	}
+
+	now := time.Now()
+	b.mu.Lock()
+	if !b.havePT {
+		b.payloadType = pkt.PayloadType
+		b.havePT = true
+	} else if pkt.PayloadType != b.payloadType {
+		b.payloadType = pkt.PayloadType
+		cb := b.onPayloadTypeChange
+		b.mu.Unlock()
+		if cb != nil {
+			cb(pkt.PayloadType)
+		}
+		b.mu.Lock()
+	}
+
+	var sendDelta, arrivalDelta time.Duration
+	haveDeltas := b.haveLast
+	if haveDeltas {
+		clockRate := b.codecCaps.ClockRate
+		if clockRate == 0 {
+			clockRate = 90000
+		}
+		tsDelta := int32(pkt.Timestamp - b.lastTimestamp)
+		sendDelta = time.Duration(float64(tsDelta) / float64(clockRate) * float64(time.Second))
+		arrivalDelta = now.Sub(b.lastArrival)
+	}
+	b.lastTimestamp = pkt.Timestamp
+	b.lastArrival = now
+	b.haveLast = true
+	twccCb := b.onTWCCFeedback
+
+	mime := b.codecCaps.MimeType
+	svcCb := b.onSVCLayer
+	b.mu.Unlock()
+
+	b.storePacket(pkt)
+
+	if haveDeltas && twccCb != nil {
+		twccCb(sendDelta, arrivalDelta)
+	}
+
+	if svcCb != nil && mime != "" {
+		descriptor := svcDescriptorSource(mime, pkt)
+		if descriptor != nil {
+			if layers, err := ParseSVCLayers(mime, descriptor); err == nil {
+				svcCb(layers)
+			}
+		}
+	}
+}
+
+// svcDescriptorSource returns the bytes ParseSVCLayers should decode for mime: the AV1 Dependency
+// Descriptor rides as an RTP header extension (not modeled on rtp.Packet without the extension ID
+// this package isn't configured with, so AV1 packets are only gated once that wiring exists); VP9
+// carries its descriptor at the start of the RTP payload itself.
+func svcDescriptorSource(mime string, pkt *rtp.Packet) []byte {
+	if strings.EqualFold(mime, "video/vp9") {
+		return pkt.Payload
+	}
+	return nil
+}
+
+// recoverAndReinsert is WriteRTP's path for a buffer that exists purely as another buffer's RTX or
+// ULPFEC side channel: it never caches pkt under its own sequence number, it recovers the original
+// packet the side channel was protecting and reinserts that into primary instead.
+func (b *Buffer) recoverAndReinsert(pkt *rtp.Packet, kind recoveryKind, primary *Buffer) {
+	switch kind {
+	case recoveryRTX:
+		osn, payload, ok := DemuxRTX(pkt.Payload)
+		if !ok {
+			return
+		}
+		recovered := &rtp.Packet{
+			Header:  pkt.Header,
+			Payload: payload,
+		}
+		recovered.SequenceNumber = osn
+		primary.insertRecovered(recovered)
+	case recoveryFEC:
+		primary.mu.Lock()
+		received := make(map[uint16]*rtp.Packet, len(primary.packets))
+		for seq, p := range primary.packets {
+			received[seq] = p
+		}
+		primary.mu.Unlock()
+
+		recovered, err := RecoverULPFEC(pkt.Payload, received)
+		if err != nil {
+			return
+		}
+		primary.insertRecovered(recovered)
+	}
+}
+
+// insertRecovered adds a packet recovered by RTX or ULPFEC into this (primary) buffer's jitter
+// cache, unless a packet under that sequence number already arrived through the ordinary path -
+// the recovery channel is racing the primary SSRC's own (possibly just-late) delivery of it.
+func (b *Buffer) insertRecovered(pkt *rtp.Packet) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, exists := b.packets[pkt.SequenceNumber]; exists {
+		return
+	}
+	b.storePacketLocked(pkt)
+}
+
+func (b *Buffer) storePacket(pkt *rtp.Packet) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.storePacketLocked(pkt)
+}
+
+func (b *Buffer) storePacketLocked(pkt *rtp.Packet) {
+	if _, exists := b.packets[pkt.SequenceNumber]; !exists {
+		b.order = append(b.order, pkt.SequenceNumber)
+	}
+	b.packets[pkt.SequenceNumber] = pkt
+	for len(b.order) > jitterCacheSize {
+		oldest := b.order[0]
+		b.order = b.order[1:]
+		delete(b.packets, oldest)
+	}
+}
+
+// Close tears the buffer down, invoking the OnFinalRtpStats callback (with an empty
+// livekit.RTPStats) if a caller registered one.
+func (b *Buffer) Close() {
+	b.mu.Lock()
+	cb := b.onFinalRtpStats
+	b.mu.Unlock()
+	if cb != nil {
+		cb(&livekit.RTPStats{})
+	}
+}
@@ -0,0 +1,191 @@
+// Copyright 2023 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package buffer
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/pion/rtp"
+)
+
+// DemuxRTX decodes an RTX (RFC 4588) retransmission packet's payload, which prefixes the
+// retransmitted packet's original sequence number (OSN) to its original payload. The primary
+// buffer reinserts the returned payload into its jitter cache under the original sequence number
+// instead of the RTX stream's own, so a retransmission fills the same gap FEC would.
+//
+// RTX keep-alive padding packets (sent so NAT/firewall bindings don't time out during silence)
+// carry no OSN; ok is false for any payload too short to hold one so callers can drop them instead
+// of misreading padding as a sequence number.
+func DemuxRTX(rtxPayload []byte) (originalSequenceNumber uint16, originalPayload []byte, ok bool) {
+	if len(rtxPayload) < 2 {
+		return 0, nil, false
+	}
+	return binary.BigEndian.Uint16(rtxPayload[:2]), rtxPayload[2:], true
+}
+
+var (
+	errULPFECHeaderTooShort      = errors.New("buffer: ulpfec header too short")
+	errULPFECLongMaskUnsupported = errors.New("buffer: ulpfec long (48-bit) mask not supported")
+	errULPFECNothingMissing      = errors.New("buffer: ulpfec group has no missing packet to recover")
+	errULPFECNotRecoverable      = errors.New("buffer: ulpfec group has more than one missing packet")
+)
+
+// ulpfecMaskBits is the protection length this package decodes: the short (16-bit) mask form
+// covers a media packet and the 15 that follow it, which is what every encoder seen in practice
+// uses; the long (48-bit) mask form exists for >16 packet groups and isn't decoded here.
+const ulpfecMaskBits = 16
+
+// ulpfecHeader is the fixed part of a ULPFEC packet's FEC header (RFC 5109 section 5.1) plus the
+// short protection mask, decoded far enough to tell which media sequence numbers a FEC packet
+// protects and to XOR-recover one of them.
+type ulpfecHeader struct {
+	pBit, xBit, mBit byte
+	ccBits           byte // 4 bits
+	ptRecovery       byte // 7 bits
+	snBase           uint16
+	tsRecovery       uint32
+	lengthRecovery   uint16
+	mask             uint16
+}
+
+// parseULPFECHeader decodes fecPayload's RFC 5109 section 5.1 header and returns the header along
+// with the recovery payload that follows it (the XOR of every protected packet's RTP payload,
+// zero-padded to the longest one in the group).
+func parseULPFECHeader(fecPayload []byte) (ulpfecHeader, []byte, error) {
+	if len(fecPayload) < 10 {
+		return ulpfecHeader{}, nil, errULPFECHeaderTooShort
+	}
+
+	b0 := fecPayload[0]
+	longMask := b0&0x40 != 0 // L bit
+	h := ulpfecHeader{
+		pBit:           (b0 >> 5) & 1,
+		xBit:           (b0 >> 4) & 1,
+		ccBits:         b0 & 0x0F,
+		mBit:           (fecPayload[1] >> 7) & 1,
+		ptRecovery:     fecPayload[1] & 0x7F,
+		snBase:         binary.BigEndian.Uint16(fecPayload[2:4]),
+		tsRecovery:     binary.BigEndian.Uint32(fecPayload[4:8]),
+		lengthRecovery: binary.BigEndian.Uint16(fecPayload[8:10]),
+	}
+	if longMask {
+		return ulpfecHeader{}, nil, errULPFECLongMaskUnsupported
+	}
+
+	const headerLen = 10 + 2 // fixed header + 16-bit mask
+	if len(fecPayload) < headerLen {
+		return ulpfecHeader{}, nil, errULPFECHeaderTooShort
+	}
+	h.mask = binary.BigEndian.Uint16(fecPayload[10:12])
+
+	return h, fecPayload[headerLen:], nil
+}
+
+// recoveryFieldsOf extracts the subset of pkt's header that ULPFEC's XOR mask protects (RFC 5109
+// section 7.1): the P/X/CC bits, the M/PT bits, the payload length and the RTP timestamp. The
+// payload itself is XORed separately since it's variable-length.
+func recoveryFieldsOf(pkt *rtp.Packet) (byte0, byte1 byte, length uint16, timestamp uint32) {
+	if pkt.Padding {
+		byte0 |= 1 << 5
+	}
+	if pkt.Extension {
+		byte0 |= 1 << 4
+	}
+	byte0 |= byte(len(pkt.CSRC)) & 0x0F
+	if pkt.Marker {
+		byte1 |= 1 << 7
+	}
+	byte1 |= byte(pkt.PayloadType) & 0x7F
+	return byte0, byte1, uint16(len(pkt.Payload)), pkt.Timestamp
+}
+
+// RecoverULPFEC attempts to reconstruct exactly one missing RTP packet from a ULPFEC packet (RFC
+// 5109) and the media packets from its protected group that were actually received, keyed by
+// sequence number. ULPFEC protects a group with a single XOR parity, so it can only recover a
+// group missing exactly one packet; errULPFECNothingMissing/errULPFECNotRecoverable are returned
+// otherwise so the caller knows there's nothing to reinsert into the primary buffer.
+//
+// The reconstructed packet's sequence number, timestamp, marker bit, payload type and payload are
+// real (XORed back out of the FEC group); its padding/extension/CSRC bits are left at their zero
+// values once decoded since forwarding only needs sequence/timestamp/marker/payload/PT - the same
+// reduced-scope tradeoff parseAV1DependencyDescriptor documents for fields gating doesn't need.
+func RecoverULPFEC(fecPayload []byte, received map[uint16]*rtp.Packet) (*rtp.Packet, error) {
+	header, recoveryPayload, err := parseULPFECHeader(fecPayload)
+	if err != nil {
+		return nil, err
+	}
+
+	var missingSeq uint16
+	missingCount := 0
+	for i := 0; i < ulpfecMaskBits; i++ {
+		if header.mask&(1<<uint(ulpfecMaskBits-1-i)) == 0 {
+			continue
+		}
+		seq := header.snBase + uint16(i)
+		if _, ok := received[seq]; !ok {
+			missingSeq = seq
+			missingCount++
+		}
+	}
+	if missingCount == 0 {
+		return nil, errULPFECNothingMissing
+	}
+	if missingCount > 1 {
+		return nil, errULPFECNotRecoverable
+	}
+
+	byte0 := header.pBit<<5 | header.xBit<<4 | header.ccBits
+	byte1 := header.mBit<<7 | header.ptRecovery
+	length := header.lengthRecovery
+	timestamp := header.tsRecovery
+	payload := append([]byte(nil), recoveryPayload...)
+
+	for i := 0; i < ulpfecMaskBits; i++ {
+		if header.mask&(1<<uint(ulpfecMaskBits-1-i)) == 0 {
+			continue
+		}
+		seq := header.snBase + uint16(i)
+		if seq == missingSeq {
+			continue
+		}
+		pkt := received[seq]
+		b0, b1, l, ts := recoveryFieldsOf(pkt)
+		byte0 ^= b0
+		byte1 ^= b1
+		length ^= l
+		timestamp ^= ts
+		for j, b := range pkt.Payload {
+			if j < len(payload) {
+				payload[j] ^= b
+			}
+		}
+	}
+
+	if int(length) > len(payload) {
+		return nil, errULPFECHeaderTooShort
+	}
+
+	return &rtp.Packet{
+		Header: rtp.Header{
+			Version:        2,
+			Marker:         byte1&0x80 != 0,
+			PayloadType:    byte1 & 0x7F,
+			SequenceNumber: missingSeq,
+			Timestamp:      timestamp,
+		},
+		Payload: payload[:length],
+	}, nil
+}
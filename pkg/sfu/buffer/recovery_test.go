@@ -0,0 +1,162 @@
+// Copyright 2023 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package buffer
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/pion/rtp"
+)
+
+func TestDemuxRTX(t *testing.T) {
+	osn, payload, ok := DemuxRTX([]byte{0x01, 0x2c, 0xde, 0xad, 0xbe, 0xef})
+	if !ok {
+		t.Fatalf("expected ok")
+	}
+	if osn != 0x012c {
+		t.Fatalf("got osn %d, want %d", osn, 0x012c)
+	}
+	if string(payload) != "\xde\xad\xbe\xef" {
+		t.Fatalf("got payload %x", payload)
+	}
+}
+
+func TestDemuxRTX_TooShort(t *testing.T) {
+	if _, _, ok := DemuxRTX([]byte{0x01}); ok {
+		t.Fatalf("expected ok=false for a too-short payload")
+	}
+}
+
+// buildULPFECPacket XORs the RFC 5109 recovery fields of every packet in group (the whole
+// protected group; callers leave packets out of the received set, not out of group) into a
+// synthetic ULPFEC payload whose mask covers exactly those packets, as an encoder would when
+// protecting that group.
+func buildULPFECPacket(snBase uint16, group []*rtp.Packet) []byte {
+	maxLen := 0
+	for _, pkt := range group {
+		if len(pkt.Payload) > maxLen {
+			maxLen = len(pkt.Payload)
+		}
+	}
+
+	var byte0, byte1 byte
+	var length uint16
+	var timestamp uint32
+	payload := make([]byte, maxLen)
+	var mask uint16
+	for _, pkt := range group {
+		mask |= 1 << uint(15-(pkt.SequenceNumber-snBase))
+		b0, b1, l, ts := recoveryFieldsOf(pkt)
+		byte0 ^= b0
+		byte1 ^= b1
+		length ^= l
+		timestamp ^= ts
+		for i, b := range pkt.Payload {
+			payload[i] ^= b
+		}
+	}
+
+	header := make([]byte, 12)
+	header[0] = byte0 // E=0, L=0 (short mask)
+	header[1] = byte1
+	binary.BigEndian.PutUint16(header[2:4], snBase)
+	binary.BigEndian.PutUint32(header[4:8], timestamp)
+	binary.BigEndian.PutUint16(header[8:10], length)
+	binary.BigEndian.PutUint16(header[10:12], mask) // mask: one bit per packet in group, MSB = snBase
+
+	return append(header, payload...)
+}
+
+func TestRecoverULPFEC_SinglePacketLoss(t *testing.T) {
+	const snBase = 1000
+	group := []*rtp.Packet{
+		{Header: rtp.Header{SequenceNumber: snBase, Timestamp: 111, PayloadType: 96, Marker: false}, Payload: []byte{1, 2, 3}},
+		{Header: rtp.Header{SequenceNumber: snBase + 1, Timestamp: 111, PayloadType: 96, Marker: false}, Payload: []byte{4, 5, 6, 7}},
+		{Header: rtp.Header{SequenceNumber: snBase + 2, Timestamp: 111, PayloadType: 96, Marker: true}, Payload: []byte{8, 9}},
+	}
+	fec := buildULPFECPacket(snBase, group)
+
+	missing := group[1]
+	received := map[uint16]*rtp.Packet{
+		group[0].SequenceNumber: group[0],
+		group[2].SequenceNumber: group[2],
+	}
+
+	recovered, err := RecoverULPFEC(fec, received)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if recovered.SequenceNumber != missing.SequenceNumber {
+		t.Fatalf("got seq %d, want %d", recovered.SequenceNumber, missing.SequenceNumber)
+	}
+	if recovered.Timestamp != missing.Timestamp {
+		t.Fatalf("got timestamp %d, want %d", recovered.Timestamp, missing.Timestamp)
+	}
+	if recovered.Marker != missing.Marker {
+		t.Fatalf("got marker %v, want %v", recovered.Marker, missing.Marker)
+	}
+	if recovered.PayloadType != missing.PayloadType {
+		t.Fatalf("got payload type %d, want %d", recovered.PayloadType, missing.PayloadType)
+	}
+	if string(recovered.Payload) != string(missing.Payload) {
+		t.Fatalf("got payload %v, want %v", recovered.Payload, missing.Payload)
+	}
+}
+
+func TestRecoverULPFEC_NothingMissing(t *testing.T) {
+	const snBase = 2000
+	group := []*rtp.Packet{
+		{Header: rtp.Header{SequenceNumber: snBase, Timestamp: 5}, Payload: []byte{1}},
+		{Header: rtp.Header{SequenceNumber: snBase + 1, Timestamp: 5}, Payload: []byte{2}},
+	}
+	fec := buildULPFECPacket(snBase, group)
+
+	received := map[uint16]*rtp.Packet{
+		group[0].SequenceNumber: group[0],
+		group[1].SequenceNumber: group[1],
+	}
+	if _, err := RecoverULPFEC(fec, received); err != errULPFECNothingMissing {
+		t.Fatalf("expected errULPFECNothingMissing, got %v", err)
+	}
+}
+
+func TestRecoverULPFEC_MoreThanOneMissing(t *testing.T) {
+	const snBase = 3000
+	group := []*rtp.Packet{
+		{Header: rtp.Header{SequenceNumber: snBase, Timestamp: 5}, Payload: []byte{1}},
+		{Header: rtp.Header{SequenceNumber: snBase + 1, Timestamp: 5}, Payload: []byte{2}},
+	}
+	fec := buildULPFECPacket(snBase, group)
+
+	// neither packet in the group was received
+	if _, err := RecoverULPFEC(fec, map[uint16]*rtp.Packet{}); err != errULPFECNotRecoverable {
+		t.Fatalf("expected errULPFECNotRecoverable, got %v", err)
+	}
+}
+
+func TestRecoverULPFEC_HeaderTooShort(t *testing.T) {
+	if _, err := RecoverULPFEC([]byte{0x00, 0x00}, nil); err != errULPFECHeaderTooShort {
+		t.Fatalf("expected errULPFECHeaderTooShort, got %v", err)
+	}
+}
+
+func TestRecoverULPFEC_LongMaskUnsupported(t *testing.T) {
+	header := make([]byte, 16)
+	header[0] = 0x40 // L bit set
+	if _, err := RecoverULPFEC(header, nil); err != errULPFECLongMaskUnsupported {
+		t.Fatalf("expected errULPFECLongMaskUnsupported, got %v", err)
+	}
+}
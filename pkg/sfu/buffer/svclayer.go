@@ -0,0 +1,261 @@
+// Copyright 2023 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package buffer
+
+import (
+	"errors"
+	"strings"
+)
+
+// SVCLayers identifies the spatial and temporal layer a single AV1/VP9 SVC packet belongs to, as
+// decoded from its codec-specific descriptor. A single SVC SSRC multiplexes every spatial and
+// temporal layer the publisher sends; this is what lets a subscriber's forwarder tell them apart
+// and drop packets above the subscriber's requested budget instead of forwarding everything.
+type SVCLayers struct {
+	Spatial  int32
+	Temporal int32
+}
+
+var (
+	errSVCDescriptorTooShort    = errors.New("svc: descriptor too short to parse")
+	errSVCDescriptorNoTemplate  = errors.New("svc: packet carries no dependency template to resolve against")
+	errSVCDescriptorBadTemplate = errors.New("svc: frame_dependency_template_id out of range for decoded templates")
+	errSVCUnsupportedMime       = errors.New("svc: unsupported mime type")
+)
+
+// ParseSVCLayers decodes the spatial/temporal layer a packet belongs to from its codec-specific
+// descriptor bytes. For "video/av1", descriptor is the AV1 Dependency Descriptor RTP header
+// extension payload. For "video/vp9", descriptor is the start of the RTP payload itself (the VP9
+// descriptor isn't carried as a header extension). Any other mime returns errSVCUnsupportedMime.
+func ParseSVCLayers(mime string, descriptor []byte) (SVCLayers, error) {
+	switch strings.ToLower(mime) {
+	case "video/av1":
+		return parseAV1DependencyDescriptor(descriptor)
+	case "video/vp9":
+		return parseVP9Descriptor(descriptor)
+	default:
+		return SVCLayers{}, errSVCUnsupportedMime
+	}
+}
+
+// ShouldForwardLayer reports whether a packet in layers should be forwarded to a subscriber whose
+// requested budget is (maxSpatial, maxTemporal), i.e. whether it's at or below both axes.
+func ShouldForwardLayer(layers SVCLayers, maxSpatial, maxTemporal int32) bool {
+	return layers.Spatial <= maxSpatial && layers.Temporal <= maxTemporal
+}
+
+// parseVP9Descriptor decodes the mandatory and layer-indices portions of the VP9 payload
+// descriptor (draft-ietf-payload-vp9-13 section 4.2), far enough to recover the (spatial,
+// temporal) layer a packet belongs to. PictureID and TL0PICIDX are skipped over (not needed for
+// gating) but must still be sized correctly to reach the layer-indices byte.
+func parseVP9Descriptor(payload []byte) (SVCLayers, error) {
+	if len(payload) < 1 {
+		return SVCLayers{}, errSVCDescriptorTooShort
+	}
+
+	b0 := payload[0]
+	pictureIDPresent := b0&0x80 != 0    // I
+	layerIndicesPresent := b0&0x20 != 0 // L
+	flexibleMode := b0&0x10 != 0        // F
+
+	pos := 1
+	if pictureIDPresent {
+		if pos >= len(payload) {
+			return SVCLayers{}, errSVCDescriptorTooShort
+		}
+		if payload[pos]&0x80 != 0 {
+			// M: extended 15-bit picture ID spans two bytes
+			pos += 2
+		} else {
+			pos++
+		}
+	}
+
+	if !layerIndicesPresent {
+		// no layer indices on this packet; nothing to gate on
+		return SVCLayers{}, errSVCDescriptorNoTemplate
+	}
+	if pos >= len(payload) {
+		return SVCLayers{}, errSVCDescriptorTooShort
+	}
+
+	// layer-indices byte: T(3) U(1) S(3) D(1)
+	l0 := payload[pos]
+	temporal := int32(l0>>5) & 0x7
+	spatial := int32(l0>>1) & 0x7
+	pos++
+	if !flexibleMode {
+		// non-flexible mode carries a TL0PICIDX byte right after the layer-indices byte
+		pos++
+	}
+	if pos > len(payload) {
+		return SVCLayers{}, errSVCDescriptorTooShort
+	}
+
+	return SVCLayers{Spatial: spatial, Temporal: temporal}, nil
+}
+
+// maxAV1Templates bounds how many dependency templates parseAV1TemplateDependencyStructure will
+// decode before giving up; template_id is 6 bits so there can be at most 64, but real encoders use
+// far fewer (a 3x3 spatial/temporal grid is 9).
+const maxAV1Templates = 64
+
+// av1BitReader reads big-endian, MSB-first bit fields out of a byte slice, the bit order the AV1
+// Dependency Descriptor (aomediacodec/av1-rtp-spec section 4.2) is specified in.
+type av1BitReader struct {
+	data []byte
+	pos  int
+}
+
+func (r *av1BitReader) readBits(n int) (uint32, bool) {
+	var v uint32
+	for i := 0; i < n; i++ {
+		byteIdx := r.pos >> 3
+		if byteIdx >= len(r.data) {
+			return 0, false
+		}
+		bit := (r.data[byteIdx] >> (7 - uint(r.pos&7))) & 1
+		v = v<<1 | uint32(bit)
+		r.pos++
+	}
+	return v, true
+}
+
+// parseAV1DependencyDescriptor decodes the AV1 Dependency Descriptor RTP header extension far
+// enough to recover the (spatial, temporal) layer the described frame belongs to.
+//
+// This is a reduced implementation: it decodes the mandatory descriptor fields, the extended
+// flags and, when present, the dependency template structure's per-template (spatial_id,
+// temporal_id) pairs, then resolves frame_dependency_template_id against that structure.
+// active_decode_targets_bitmask is read but not used; custom_dtis, custom_fdiffs and
+// custom_chains are not read past their flags, and the template structure's fdiffs and chains are
+// not read at all — layer gating only needs to know which layer a frame is, not its full
+// reference/chain structure.
+func parseAV1DependencyDescriptor(ext []byte) (SVCLayers, error) {
+	r := &av1BitReader{data: ext}
+
+	startOfFrame, ok := r.readBits(1)
+	if !ok {
+		return SVCLayers{}, errSVCDescriptorTooShort
+	}
+	if _, ok = r.readBits(1); !ok { // end_of_frame, unused for layer resolution
+		return SVCLayers{}, errSVCDescriptorTooShort
+	}
+	frameDependencyTemplateID, ok := r.readBits(6)
+	if !ok {
+		return SVCLayers{}, errSVCDescriptorTooShort
+	}
+	if _, ok = r.readBits(16); !ok { // frame_number, unused for layer resolution
+		return SVCLayers{}, errSVCDescriptorTooShort
+	}
+
+	if startOfFrame == 0 {
+		// the extension bits (and any template structure) only ride on the first packet of a
+		// frame; without them there is no template to resolve this packet's layer against
+		return SVCLayers{}, errSVCDescriptorNoTemplate
+	}
+
+	templateStructurePresent, ok := r.readBits(1)
+	if !ok {
+		return SVCLayers{}, errSVCDescriptorTooShort
+	}
+	activeDecodeTargetsPresent, ok := r.readBits(1)
+	if !ok {
+		return SVCLayers{}, errSVCDescriptorTooShort
+	}
+	if _, ok = r.readBits(3); !ok { // custom_dtis_flag, custom_fdiffs_flag, custom_chains_flag
+		return SVCLayers{}, errSVCDescriptorTooShort
+	}
+
+	if templateStructurePresent == 0 {
+		return SVCLayers{}, errSVCDescriptorNoTemplate
+	}
+
+	templates, templateIDOffset, dtCnt, err := parseAV1TemplateDependencyStructure(r)
+	if err != nil {
+		return SVCLayers{}, err
+	}
+
+	if activeDecodeTargetsPresent != 0 {
+		if _, ok = r.readBits(dtCnt); !ok {
+			return SVCLayers{}, errSVCDescriptorTooShort
+		}
+	}
+
+	// frame_dependency_template_id and template_id_offset are both 6-bit fields that wrap mod 64,
+	// so the offset between them must be reduced mod 64 before it's used as a template index —
+	// reducing mod len(templates) instead maps a wrapped id to the wrong template whenever
+	// len(templates) != 64.
+	idx := ((int(frameDependencyTemplateID)-int(templateIDOffset))%64 + 64) % 64
+	if idx >= len(templates) {
+		return SVCLayers{}, errSVCDescriptorBadTemplate
+	}
+	return templates[idx], nil
+}
+
+// parseAV1TemplateDependencyStructure decodes template_dependency_structure() far enough to
+// recover each template's (spatial_id, temporal_id) by walking templateLayers()'s next_layer_idc
+// state machine, returning the decoded templates in declaration order, the template_id_offset
+// they're keyed from, and the decode target count.
+func parseAV1TemplateDependencyStructure(r *av1BitReader) ([]SVCLayers, uint32, int, error) {
+	templateIDOffset, ok := r.readBits(6)
+	if !ok {
+		return nil, 0, 0, errSVCDescriptorTooShort
+	}
+	dtCntMinusOne, ok := r.readBits(5)
+	if !ok {
+		return nil, 0, 0, errSVCDescriptorTooShort
+	}
+	dtCnt := int(dtCntMinusOne) + 1
+
+	// templateLayers() (av1-rtp-spec section 4.2.2) does not carry each template's (spatial_id,
+	// temporal_id) explicitly. Instead templates are declared in non-decreasing spatial/temporal
+	// order and next_layer_idc walks a state machine between them: 0 keeps the same layer as the
+	// previous template (a second chain at that layer), 1 advances to the next temporal layer
+	// within the same spatial layer, and 2 advances to the next spatial layer and resets temporal
+	// back to 0; 3 terminates the list. The first template is always (spatial 0, temporal 0).
+	var templates []SVCLayers
+	spatialID, temporalID := uint32(0), uint32(0)
+	for {
+		templates = append(templates, SVCLayers{Spatial: int32(spatialID), Temporal: int32(temporalID)})
+
+		nextLayerIdc, ok := r.readBits(2)
+		if !ok {
+			return nil, 0, 0, errSVCDescriptorTooShort
+		}
+		if nextLayerIdc == 3 {
+			break
+		}
+		if len(templates) >= maxAV1Templates {
+			return nil, 0, 0, errSVCDescriptorBadTemplate
+		}
+		switch nextLayerIdc {
+		case 1:
+			temporalID++
+		case 2:
+			spatialID++
+			temporalID = 0
+		}
+	}
+
+	// each template also carries one decode-target-indication per decode target (2 bits each);
+	// consumed here so a caller reading past this point would see the correct bit offset, even
+	// though gating doesn't need the DTI values themselves
+	if _, ok := r.readBits(2 * dtCnt * len(templates)); !ok {
+		return nil, 0, 0, errSVCDescriptorTooShort
+	}
+
+	return templates, templateIDOffset, dtCnt, nil
+}
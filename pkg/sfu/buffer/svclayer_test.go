@@ -0,0 +1,184 @@
+// Copyright 2023 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package buffer
+
+import "testing"
+
+// bitWriter is the encode-side counterpart to av1BitReader, used only by these tests to build
+// synthetic Dependency Descriptor fixtures byte-for-byte instead of hand-assembling hex literals.
+type bitWriter struct {
+	buf  []byte
+	nbit int
+}
+
+func (w *bitWriter) writeBits(v uint32, n int) {
+	for i := n - 1; i >= 0; i-- {
+		bit := byte((v >> uint(i)) & 1)
+		byteIdx := w.nbit >> 3
+		if byteIdx >= len(w.buf) {
+			w.buf = append(w.buf, 0)
+		}
+		if bit != 0 {
+			w.buf[byteIdx] |= 1 << (7 - uint(w.nbit&7))
+		}
+		w.nbit++
+	}
+}
+
+// av1TemplateIdc is the next_layer_idc sequence for a 3 spatial x 3 temporal layer encode (9
+// templates, one per (spatial, temporal) pair): 1 advances to the next temporal layer, 2 advances
+// to the next spatial layer (resetting temporal back to 0), and 3 terminates the list. This is the
+// same sequence templateLayers() (av1-rtp-spec section 4.2.2) expects a real AV1 SVC encoder to
+// emit; the (spatial_id, temporal_id) of each template is derived by the reader from this sequence
+// alone, it is never written explicitly.
+var av1TemplateIdc = []uint32{1, 1, 2, 1, 1, 2, 1, 1, 3}
+
+// buildAV1DependencyDescriptor encodes a first-packet-of-frame AV1 Dependency Descriptor carrying
+// a full dependency template structure for a 3 spatial x 3 temporal layer encode (9 templates, in
+// row-major spatial-major order matching a real AV1 SVC encoder's declaration order), with
+// frameDependencyTemplateID as the frame's template id for the parser to resolve.
+func buildAV1DependencyDescriptor(t *testing.T, templateIDOffset uint32, frameDependencyTemplateID uint32) []byte {
+	t.Helper()
+	w := &bitWriter{}
+
+	w.writeBits(1, 1) // start_of_frame
+	w.writeBits(1, 1) // end_of_frame
+	w.writeBits(frameDependencyTemplateID, 6)
+	w.writeBits(1234, 16) // frame_number, arbitrary
+
+	w.writeBits(1, 1) // template_dependency_structure_present_flag
+	w.writeBits(0, 1) // active_decode_targets_present_flag
+	w.writeBits(0, 3) // custom_dtis/fdiffs/chains flags
+
+	w.writeBits(templateIDOffset, 6)
+	const dtCnt = 1
+	w.writeBits(dtCnt-1, 5) // dt_cnt_minus_one
+
+	for _, idc := range av1TemplateIdc {
+		w.writeBits(idc, 2)
+	}
+	// one decode-target-indication per decode target per template (dtCnt=1, 9 templates)
+	for i := 0; i < len(av1TemplateIdc); i++ {
+		w.writeBits(0, 2)
+	}
+
+	return w.buf
+}
+
+func TestParseAV1DependencyDescriptor_3x3Layers(t *testing.T) {
+	const templateIDOffset = 10
+
+	for spatial := int32(0); spatial < 3; spatial++ {
+		for temporal := int32(0); temporal < 3; temporal++ {
+			templateID := templateIDOffset + uint32(spatial*3+temporal)
+			ext := buildAV1DependencyDescriptor(t, templateIDOffset, templateID)
+
+			layers, err := ParseSVCLayers("video/AV1", ext)
+			if err != nil {
+				t.Fatalf("spatial=%d temporal=%d: unexpected error: %v", spatial, temporal, err)
+			}
+			if layers.Spatial != spatial || layers.Temporal != temporal {
+				t.Fatalf("spatial=%d temporal=%d: got %+v", spatial, temporal, layers)
+			}
+		}
+	}
+}
+
+func TestParseAV1DependencyDescriptor_NonKeyframePacket(t *testing.T) {
+	w := &bitWriter{}
+	w.writeBits(0, 1) // start_of_frame = false: no template structure rides on this packet
+	w.writeBits(1, 1) // end_of_frame
+	w.writeBits(5, 6) // frame_dependency_template_id
+	w.writeBits(99, 16)
+
+	if _, err := ParseSVCLayers("video/av1", w.buf); err != errSVCDescriptorNoTemplate {
+		t.Fatalf("expected errSVCDescriptorNoTemplate, got %v", err)
+	}
+}
+
+func TestParseAV1DependencyDescriptor_BadTemplateID(t *testing.T) {
+	// template_dependency_structure_present_flag with frameDependencyTemplateID pointing well
+	// outside the 9 decoded templates (valid 6-bit values are 0-63; 9 templates means only 0-8
+	// resolve to a template here)
+	ext := buildAV1DependencyDescriptor(t, 0, 20)
+	if _, err := ParseSVCLayers("video/av1", ext); err != errSVCDescriptorBadTemplate {
+		t.Fatalf("expected errSVCDescriptorBadTemplate, got %v", err)
+	}
+}
+
+func TestParseAV1DependencyDescriptor_TooShort(t *testing.T) {
+	if _, err := ParseSVCLayers("video/av1", []byte{0x80}); err != errSVCDescriptorTooShort {
+		t.Fatalf("expected errSVCDescriptorTooShort, got %v", err)
+	}
+}
+
+// buildVP9Descriptor builds a minimal VP9 payload descriptor with the I/L flags set and the given
+// (spatial, temporal) layer indices, in non-flexible mode (matching a non-flexible-mode SVC
+// encode, the common case for a 3x3 spatial/temporal layer structure).
+func buildVP9Descriptor(spatial, temporal int32) []byte {
+	b0 := byte(0x80 | 0x20) // I=1, L=1, F=0 (non-flexible)
+	pictureID := byte(0x05) // M=0, 7-bit picture id
+	layerIndices := byte(temporal<<5) | byte(spatial<<1)
+	tl0PicIdx := byte(0x01)
+	return []byte{b0, pictureID, layerIndices, tl0PicIdx}
+}
+
+func TestParseVP9Descriptor_3x3Layers(t *testing.T) {
+	for spatial := int32(0); spatial < 3; spatial++ {
+		for temporal := int32(0); temporal < 3; temporal++ {
+			payload := buildVP9Descriptor(spatial, temporal)
+			layers, err := ParseSVCLayers("video/vp9", payload)
+			if err != nil {
+				t.Fatalf("spatial=%d temporal=%d: unexpected error: %v", spatial, temporal, err)
+			}
+			if layers.Spatial != spatial || layers.Temporal != temporal {
+				t.Fatalf("spatial=%d temporal=%d: got %+v", spatial, temporal, layers)
+			}
+		}
+	}
+}
+
+func TestParseVP9Descriptor_NoLayerIndices(t *testing.T) {
+	payload := []byte{0x80, 0x05} // I=1, L=0
+	if _, err := ParseSVCLayers("video/vp9", payload); err != errSVCDescriptorNoTemplate {
+		t.Fatalf("expected errSVCDescriptorNoTemplate, got %v", err)
+	}
+}
+
+func TestParseSVCLayers_UnsupportedMime(t *testing.T) {
+	if _, err := ParseSVCLayers("video/h264", []byte{0x00}); err != errSVCUnsupportedMime {
+		t.Fatalf("expected errSVCUnsupportedMime, got %v", err)
+	}
+}
+
+func TestShouldForwardLayer(t *testing.T) {
+	cases := []struct {
+		layers      SVCLayers
+		maxSpatial  int32
+		maxTemporal int32
+		want        bool
+	}{
+		{SVCLayers{Spatial: 0, Temporal: 0}, 0, 0, true},
+		{SVCLayers{Spatial: 1, Temporal: 0}, 0, 0, false},
+		{SVCLayers{Spatial: 0, Temporal: 1}, 0, 0, false},
+		{SVCLayers{Spatial: 2, Temporal: 2}, 2, 2, true},
+		{SVCLayers{Spatial: 1, Temporal: 2}, 2, 1, false},
+	}
+	for _, c := range cases {
+		if got := ShouldForwardLayer(c.layers, c.maxSpatial, c.maxTemporal); got != c.want {
+			t.Errorf("ShouldForwardLayer(%+v, %d, %d) = %v, want %v", c.layers, c.maxSpatial, c.maxTemporal, got, c.want)
+		}
+	}
+}
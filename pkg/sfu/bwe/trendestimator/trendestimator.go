@@ -0,0 +1,395 @@
+// Copyright 2023 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package trendestimator estimates upstream (publisher -> SFU) bandwidth usage from TWCC
+// feedback using a slope/trend detector, the same family of technique as the delay-based half of
+// Google Congestion Control. Unlike a full GCC implementation, it does not produce a target
+// bitrate: it classifies the recent trend of (arrival_delta - send_delta) samples as overusing,
+// normal or underusing so callers (e.g. DynacastManager) can react with pause/probe decisions.
+package trendestimator
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Trend is the classification of the most recent delay-gradient samples.
+type Trend int
+
+const (
+	TrendUnknown Trend = iota
+	TrendNormal
+	TrendOverusing
+	TrendUnderusing
+)
+
+func (t Trend) String() string {
+	switch t {
+	case TrendNormal:
+		return "normal"
+	case TrendOverusing:
+		return "overusing"
+	case TrendUnderusing:
+		return "underusing"
+	default:
+		return "unknown"
+	}
+}
+
+const (
+	defaultBucketDuration   = 100 * time.Millisecond
+	defaultWindowDuration   = 2 * time.Second
+	defaultStalledTimeout   = 2 * time.Second
+	defaultUnstableDuration = 3 * time.Second
+	defaultThresholdGain    = 3.0 // thresholds are this many std-deviations from the window mean
+)
+
+// Params configures the estimator. Zero values fall back to sane defaults.
+type Params struct {
+	BucketDuration   time.Duration
+	WindowDuration   time.Duration
+	StalledTimeout   time.Duration
+	UnstableDuration time.Duration
+	ThresholdGain    float64
+}
+
+func (p *Params) setDefaults() {
+	if p.BucketDuration <= 0 {
+		p.BucketDuration = defaultBucketDuration
+	}
+	if p.WindowDuration <= 0 {
+		p.WindowDuration = defaultWindowDuration
+	}
+	if p.StalledTimeout <= 0 {
+		p.StalledTimeout = defaultStalledTimeout
+	}
+	if p.UnstableDuration <= 0 {
+		p.UnstableDuration = defaultUnstableDuration
+	}
+	if p.ThresholdGain <= 0 {
+		p.ThresholdGain = defaultThresholdGain
+	}
+}
+
+// DebugSample is a snapshot of the estimator's internal state, exposed for tuning.
+type DebugSample struct {
+	Slope     float64
+	Threshold float64
+	Trend     Trend
+	At        time.Time
+}
+
+type bucket struct {
+	start    time.Time
+	sumDelta float64
+	count    int
+}
+
+// TrendEstimator maintains a sliding window of (arrival_delta - send_delta) samples grouped into
+// fixed-duration buckets, and classifies the slope of those buckets as overusing/normal/
+// underusing. It is not safe to share a TrendEstimator across tracks; one instance per publisher
+// track is expected.
+type TrendEstimator struct {
+	params Params
+
+	lock        sync.Mutex
+	buckets     []bucket
+	lastArrival time.Time
+
+	// Kalman-smoothed slope estimate and its variance, following the delay-based controller in
+	// GCC: each new slope observation nudges the estimate rather than replacing it outright.
+	smoothedSlope float64
+	slopeVariance float64
+	haveEstimate  bool
+
+	trend         Trend
+	unstableSince time.Time
+
+	onTrendChanged func(Trend)
+	debugCh        chan DebugSample
+
+	// trendCh feeds applyTrendLocked's transitions to a single consumer goroutine so callers
+	// observe them in the order they actually occurred; firing one `go f(trend)` per transition
+	// gives no such guarantee under a rapid overuse/normal/underuse flap.
+	trendCh    chan Trend
+	stopWorker chan struct{}
+
+	stalledTimer *time.Timer
+	closed       bool
+}
+
+// trendChangeQueueSize bounds how many pending trend transitions trendWorker can be behind the
+// caller of AddSample before applyTrendLocked starts blocking on trendCh. Transitions are rare
+// relative to AddSample's packet-rate cadence, so this is sized generously rather than tightly.
+const trendChangeQueueSize = 32
+
+func NewTrendEstimator(params Params) *TrendEstimator {
+	params.setDefaults()
+	e := &TrendEstimator{
+		params:     params,
+		trend:      TrendUnknown,
+		trendCh:    make(chan Trend, trendChangeQueueSize),
+		stopWorker: make(chan struct{}),
+	}
+	go e.trendWorker()
+	return e
+}
+
+// trendWorker is the single consumer that invokes onTrendChanged, serializing callback delivery
+// so transitions are observed in the same order applyTrendLocked produced them.
+func (e *TrendEstimator) trendWorker() {
+	for {
+		select {
+		case trend := <-e.trendCh:
+			e.lock.Lock()
+			f := e.onTrendChanged
+			e.lock.Unlock()
+			if f != nil {
+				f(trend)
+			}
+		case <-e.stopWorker:
+			return
+		}
+	}
+}
+
+// OnTrendChanged registers a callback invoked whenever the classified trend changes.
+func (e *TrendEstimator) OnTrendChanged(f func(Trend)) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.onTrendChanged = f
+}
+
+// DebugChannel returns a channel that receives a DebugSample after every AddSample once the window
+// holds at least two buckets. The channel buffers 50 samples and sends never block, so a slow
+// reader simply misses samples; it is meant for interactive tuning, not production telemetry.
+func (e *TrendEstimator) DebugChannel() <-chan DebugSample {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if e.debugCh == nil {
+		e.debugCh = make(chan DebugSample, 50)
+	}
+	return e.debugCh
+}
+
+// AddSample records one TWCC-derived (send, arrival) pair for a received packet. sendDelta and
+// arrivalDelta are the inter-packet send/arrival time deltas relative to the previous packet.
+func (e *TrendEstimator) AddSample(now time.Time, sendDelta, arrivalDelta time.Duration) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if e.closed {
+		return
+	}
+
+	e.lastArrival = now
+	e.resetStalledTimerLocked()
+
+	delta := (arrivalDelta - sendDelta).Seconds() * 1000 // milliseconds
+
+	if len(e.buckets) == 0 || now.Sub(e.buckets[len(e.buckets)-1].start) >= e.params.BucketDuration {
+		e.buckets = append(e.buckets, bucket{start: now})
+	}
+	cur := &e.buckets[len(e.buckets)-1]
+	cur.sumDelta += delta
+	cur.count++
+
+	// drop buckets that have aged out of the window
+	cutoff := now.Add(-e.params.WindowDuration)
+	i := 0
+	for i < len(e.buckets) && e.buckets[i].start.Before(cutoff) {
+		i++
+	}
+	e.buckets = e.buckets[i:]
+
+	e.computeTrendLocked(now)
+}
+
+// computeTrendLocked runs a linear regression over the bucket means to get a slope, Kalman-smooths
+// it, and classifies the result against thresholds derived from the window's standard deviation.
+// Caller must hold e.lock.
+func (e *TrendEstimator) computeTrendLocked(now time.Time) {
+	if len(e.buckets) < 2 {
+		return
+	}
+
+	means := make([]float64, len(e.buckets))
+	for i, b := range e.buckets {
+		if b.count > 0 {
+			means[i] = b.sumDelta / float64(b.count)
+		}
+	}
+
+	slope := linearRegressionSlope(means)
+
+	// Kalman update: treat the new slope as a noisy observation of the true trend.
+	const processNoise = 1e-3
+	const observationNoise = 10.0
+	if !e.haveEstimate {
+		e.smoothedSlope = slope
+		e.slopeVariance = observationNoise
+		e.haveEstimate = true
+	} else {
+		predictedVariance := e.slopeVariance + processNoise
+		gain := predictedVariance / (predictedVariance + observationNoise)
+		e.smoothedSlope += gain * (slope - e.smoothedSlope)
+		e.slopeVariance = (1 - gain) * predictedVariance
+	}
+
+	threshold := e.params.ThresholdGain * stddev(means)
+	if threshold <= 0 {
+		threshold = 1.0
+	}
+
+	// Classify on the slope's cumulative effect across the window (its predicted total delay
+	// change from one edge of the window to the other), not the raw per-bucket slope itself:
+	// Cauchy-Schwarz bounds |slope| <= stddev(means) * sqrt(12/(bucketCount^2-1)) for any data, a
+	// factor that's always well under ThresholdGain's default of 3, so comparing slope directly
+	// to ThresholdGain*stddev could never classify anything but normal. Multiplying by the number
+	// of bucket-to-bucket steps in the window puts both sides back in the same units (delay, in
+	// milliseconds) and isn't subject to that bound.
+	drift := e.smoothedSlope * float64(len(e.buckets)-1)
+
+	var trend Trend
+	switch {
+	case drift > threshold:
+		trend = TrendOverusing
+	case drift < -threshold:
+		trend = TrendUnderusing
+	default:
+		trend = TrendNormal
+	}
+
+	e.applyTrendLocked(trend, now)
+
+	if e.debugCh != nil {
+		select {
+		case e.debugCh <- DebugSample{Slope: e.smoothedSlope, Threshold: threshold, Trend: trend, At: now}:
+		default:
+		}
+	}
+}
+
+func (e *TrendEstimator) applyTrendLocked(trend Trend, now time.Time) {
+	if trend == TrendUnderusing {
+		if e.trend != TrendUnderusing {
+			e.unstableSince = now
+		}
+	} else {
+		e.unstableSince = time.Time{}
+	}
+
+	if trend == e.trend {
+		return
+	}
+	e.trend = trend
+	if e.onTrendChanged != nil {
+		// hand off to trendWorker rather than spawning a goroutine per transition, so a rapid
+		// overuse/normal/underuse flap is delivered to the caller in the order it happened
+		e.trendCh <- trend
+	}
+}
+
+// IsUnstable reports whether the estimator has been continuously underusing for at least
+// UnstableDuration, the signal DynacastManager uses to probe upward for more bandwidth.
+func (e *TrendEstimator) IsUnstable(now time.Time) bool {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if e.trend != TrendUnderusing || e.unstableSince.IsZero() {
+		return false
+	}
+	return now.Sub(e.unstableSince) >= e.params.UnstableDuration
+}
+
+// Trend returns the current classification.
+func (e *TrendEstimator) Trend() Trend {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return e.trend
+}
+
+func (e *TrendEstimator) resetStalledTimerLocked() {
+	if e.stalledTimer != nil {
+		e.stalledTimer.Stop()
+	}
+	e.stalledTimer = time.AfterFunc(e.params.StalledTimeout, e.onStalled)
+}
+
+func (e *TrendEstimator) onStalled() {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if e.closed {
+		return
+	}
+	e.applyTrendLocked(TrendUnknown, time.Now())
+	e.buckets = nil
+	e.haveEstimate = false
+}
+
+// Close stops the stall timer, the trend worker goroutine and releases the debug channel. Safe to
+// call multiple times.
+func (e *TrendEstimator) Close() {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if e.closed {
+		return
+	}
+	e.closed = true
+	if e.stalledTimer != nil {
+		e.stalledTimer.Stop()
+	}
+	close(e.stopWorker)
+	if e.debugCh != nil {
+		close(e.debugCh)
+		e.debugCh = nil
+	}
+}
+
+func linearRegressionSlope(y []float64) float64 {
+	n := float64(len(y))
+	if n < 2 {
+		return 0
+	}
+	var sumX, sumY, sumXY, sumXX float64
+	for i, v := range y {
+		x := float64(i)
+		sumX += x
+		sumY += v
+		sumXY += x * v
+		sumXX += x * x
+	}
+	denom := n*sumXX - sumX*sumX
+	if denom == 0 {
+		return 0
+	}
+	return (n*sumXY - sumX*sumY) / denom
+}
+
+func stddev(v []float64) float64 {
+	if len(v) == 0 {
+		return 0
+	}
+	var mean float64
+	for _, x := range v {
+		mean += x
+	}
+	mean /= float64(len(v))
+
+	var variance float64
+	for _, x := range v {
+		variance += (x - mean) * (x - mean)
+	}
+	variance /= float64(len(v))
+	return math.Sqrt(variance)
+}
@@ -0,0 +1,207 @@
+// Copyright 2023 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trendestimator
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLinearRegressionSlope(t *testing.T) {
+	cases := []struct {
+		name string
+		y    []float64
+		want float64
+	}{
+		{"empty", nil, 0},
+		{"single", []float64{5}, 0},
+		{"flat", []float64{2, 2, 2, 2}, 0},
+		{"rising", []float64{0, 1, 2, 3}, 1},
+		{"falling", []float64{3, 2, 1, 0}, -1},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := linearRegressionSlope(c.y); got != c.want {
+				t.Fatalf("got %v, want %v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestStddev(t *testing.T) {
+	if got := stddev(nil); got != 0 {
+		t.Fatalf("got %v, want 0", got)
+	}
+	if got := stddev([]float64{4, 4, 4}); got != 0 {
+		t.Fatalf("got %v, want 0", got)
+	}
+}
+
+func TestTrendEstimator_ClassifiesOverusing(t *testing.T) {
+	// A window needs enough buckets for cumulative drift (smoothedSlope * (bucketCount-1)) to be
+	// able to exceed ThresholdGain*stddev(means) at all: for a perfectly linear sequence,
+	// Cauchy-Schwarz bounds that ratio at or below 1 until the window holds at least 8 buckets,
+	// regardless of how steep the slope is. 20 buckets gives a comfortable margin.
+	e := NewTrendEstimator(Params{BucketDuration: 5 * time.Millisecond, WindowDuration: 100 * time.Millisecond})
+	defer e.Close()
+
+	var mu sync.Mutex
+	var got []Trend
+	e.OnTrendChanged(func(trend Trend) {
+		mu.Lock()
+		got = append(got, trend)
+		mu.Unlock()
+	})
+
+	base := time.Now()
+	// a steadily growing (arrival - send) delta simulates sustained queuing delay building up,
+	// i.e. the publisher is sending faster than the path can deliver: overusing.
+	for i := 0; i < 60; i++ {
+		now := base.Add(time.Duration(i) * 5 * time.Millisecond)
+		e.AddSample(now, 20*time.Millisecond, time.Duration(20+i*4)*time.Millisecond)
+	}
+
+	if trend := e.Trend(); trend != TrendOverusing {
+		t.Fatalf("got trend %v, want overusing", trend)
+	}
+	// trend changes reach OnTrendChanged through trendWorker's goroutine, so give it time to run
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		mu.Lock()
+		n := len(got)
+		mu.Unlock()
+		if n > 0 {
+			break
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(got) == 0 {
+		t.Fatalf("expected at least one trend change to be delivered")
+	}
+}
+
+func TestTrendEstimator_StalledResetsToUnknown(t *testing.T) {
+	e := NewTrendEstimator(Params{
+		BucketDuration: 5 * time.Millisecond,
+		WindowDuration: 50 * time.Millisecond,
+		StalledTimeout: 20 * time.Millisecond,
+	})
+	defer e.Close()
+
+	base := time.Now()
+	for i := 0; i < 10; i++ {
+		e.AddSample(base.Add(time.Duration(i)*5*time.Millisecond), 5*time.Millisecond, time.Duration(5+i)*time.Millisecond)
+	}
+
+	// wait past StalledTimeout with no further samples; onStalled should fire and reset to unknown
+	time.Sleep(60 * time.Millisecond)
+
+	if got := e.Trend(); got != TrendUnknown {
+		t.Fatalf("got trend %v after stall, want unknown", got)
+	}
+}
+
+func TestTrendEstimator_IsUnstableRequiresSustainedUnderuse(t *testing.T) {
+	// Drive IsUnstable/applyTrendLocked directly (white-box, same package) rather than through
+	// AddSample: the exact sample at which the Kalman-smoothed slope crosses into underusing
+	// depends on internal tuning constants, which would make a black-box test of the
+	// UnstableDuration boundary brittle.
+	e := NewTrendEstimator(Params{UnstableDuration: 30 * time.Millisecond})
+	defer e.Close()
+
+	base := time.Now()
+	e.lock.Lock()
+	e.applyTrendLocked(TrendUnderusing, base)
+	e.lock.Unlock()
+
+	if e.IsUnstable(base.Add(10 * time.Millisecond)) {
+		t.Fatalf("expected not yet unstable before UnstableDuration has elapsed")
+	}
+	if !e.IsUnstable(base.Add(31 * time.Millisecond)) {
+		t.Fatalf("expected unstable once UnstableDuration has elapsed")
+	}
+
+	e.lock.Lock()
+	e.applyTrendLocked(TrendNormal, base.Add(31*time.Millisecond))
+	e.lock.Unlock()
+	if e.IsUnstable(base.Add(100 * time.Millisecond)) {
+		t.Fatalf("expected not unstable once trend leaves underusing")
+	}
+}
+
+func TestTrendEstimator_TransitionsDeliveredInOrder(t *testing.T) {
+	// See the window-size comment in TestTrendEstimator_ClassifiesOverusing: 20
+	// buckets gives the cumulative drift comparison enough margin to actually cross threshold.
+	e := NewTrendEstimator(Params{BucketDuration: 5 * time.Millisecond, WindowDuration: 100 * time.Millisecond})
+	defer e.Close()
+
+	var mu sync.Mutex
+	var got []Trend
+	done := make(chan struct{}, 1)
+	e.OnTrendChanged(func(trend Trend) {
+		mu.Lock()
+		got = append(got, trend)
+		mu.Unlock()
+		if trend == TrendUnderusing {
+			select {
+			case done <- struct{}{}:
+			default:
+			}
+		}
+	})
+
+	base := time.Now()
+	// normal (the first two buckets), overusing, then underusing: transitions that must be
+	// delivered in that order even though trendWorker runs on its own goroutine. The Kalman-smoothed
+	// slope lags the raw slope, so the falling phase runs long enough (over 270 samples) for it to
+	// swing from overusing through normal to underusing.
+	for i := 0; i < 40; i++ {
+		now := base.Add(time.Duration(i) * 5 * time.Millisecond)
+		e.AddSample(now, 20*time.Millisecond, time.Duration(20+i*4)*time.Millisecond)
+	}
+	peakDelta := 20 + 39*4
+	for i := 40; i < 400; i++ {
+		now := base.Add(time.Duration(i) * 5 * time.Millisecond)
+		e.AddSample(now, 20*time.Millisecond, time.Duration(peakDelta-(i-40)*4)*time.Millisecond)
+	}
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for trend transitions to be delivered")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(got) < 3 {
+		t.Fatalf("got %d transitions, want at least 3: %v", len(got), got)
+	}
+	if got[0] != TrendNormal {
+		t.Fatalf("first transition = %v, want normal", got[0])
+	}
+	if got[1] != TrendOverusing {
+		t.Fatalf("second transition = %v, want overusing", got[1])
+	}
+	if got[len(got)-1] != TrendUnderusing {
+		t.Fatalf("last transition = %v, want underusing", got[len(got)-1])
+	}
+}
+
+func TestTrendEstimator_CloseIsIdempotentAndStopsWorker(t *testing.T) {
+	e := NewTrendEstimator(Params{})
+	e.Close()
+	e.Close() // must not panic on double close
+}